@@ -1,84 +1,292 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"text/tabwriter"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/otnayrus/simple-wallet-app/config"
 	"github.com/otnayrus/simple-wallet-app/delivery/rest"
 	"github.com/otnayrus/simple-wallet-app/repository"
 	"github.com/otnayrus/simple-wallet-app/service"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
 )
 
 func main() {
-	db, err := sql.Open("sqlite3", "wallet.db")
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(args) > 0 && args[0] == "config" {
+		if err = printConfig(cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := openDatabase(cfg.DBDriver, cfg.DBDSN)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-	migrate(db)
+	defer db.close()
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err = runMigration(db.migrator, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Pending migrations are applied on start. A schema migrated by a
+	// newer binary is refused rather than served with the wrong queries.
+	if db.migrator != nil {
+		if err = migrateUp(db.migrator); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(args) > 0 && args[0] == "trial-balance" {
+		ledgerService := service.NewLedgerService(db.walletRepo.Ledger())
+		if err = printTrialBalance(ledgerService); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "load-rates" {
+		if len(args) != 2 {
+			log.Fatal("usage: load-rates <file.csv>")
+		}
+		if err = loadRates(service.NewFXService(db.walletRepo.FX()), args[1]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	walletRepo := repository.NewWalletRepositiory(db)
-	walletService := service.NewWalletService(walletRepo)
+	walletService := service.NewWalletService(db.walletRepo, cfg.TokenLength)
 	walletHandler := rest.NewWalletHandler(walletService)
+	fxHandler := rest.NewFXHandler(service.NewFXService(db.walletRepo.FX()))
 
-	router := gin.Default()
-	v1 := router.Group("/api/v1")
+	router := newRouter(cfg.LogLevel)
+	v1 := router.Group("/api/v1", rest.Timeout(cfg.RequestTimeout))
 
 	v1.POST("/init", walletHandler.Initialize)
-	v1.POST("/wallet", walletHandler.Enable)
-	v1.PATCH("/wallet", walletHandler.Disable)
-	v1.GET("/wallet", walletHandler.ViewBalance)
-	v1.POST("/wallet/deposits", walletHandler.Deposit)
-	v1.POST("/wallet/withdrawals", walletHandler.Withdraw)
-	v1.GET("/wallet/transactions", walletHandler.GetMutationList)
+	wallet := v1.Group("/wallet", walletHandler.Authenticate)
+	wallet.POST("", walletHandler.RequireScope(types.ScopeAdmin), walletHandler.Enable)
+	wallet.PATCH("", walletHandler.RequireScope(types.ScopeAdmin), walletHandler.Disable)
+	wallet.GET("", walletHandler.RequireScope(types.ScopeBalanceRead), walletHandler.ViewBalance)
+	wallet.POST("/deposits", walletHandler.RequireScope(types.ScopeDeposit), walletHandler.Deposit)
+	wallet.POST("/withdrawals", walletHandler.RequireScope(types.ScopeWithdraw), walletHandler.Withdraw)
+	wallet.POST("/transfers", walletHandler.RequireScope(types.ScopeWithdraw), walletHandler.Transfer)
+	wallet.POST("/holds", walletHandler.RequireScope(types.ScopeWithdraw), walletHandler.CreateHold)
+	wallet.POST("/holds/:id/capture", walletHandler.RequireScope(types.ScopeWithdraw), walletHandler.CaptureHold)
+	wallet.POST("/holds/:id/void", walletHandler.RequireScope(types.ScopeWithdraw), walletHandler.VoidHold)
+	wallet.GET("/transactions", walletHandler.RequireScope(types.ScopeTransactionsRead), walletHandler.GetMutationList)
+	wallet.GET("/transactions/export", walletHandler.RequireScope(types.ScopeTransactionsRead), walletHandler.ExportMutationList)
+	wallet.POST("/fx/quotes", walletHandler.RequireScope(types.ScopeWithdraw), walletHandler.CreateFXQuote)
+	wallet.POST("/fx/conversions", walletHandler.RequireScope(types.ScopeWithdraw), walletHandler.ConvertFX)
+	wallet.GET("/tokens", walletHandler.RequireScope(types.ScopeAdmin), walletHandler.ListTokens)
+	wallet.POST("/tokens", walletHandler.RequireScope(types.ScopeAdmin), walletHandler.CreateToken)
+	// Any token may rotate itself; the new token keeps its scopes.
+	wallet.POST("/tokens/rotate", walletHandler.RotateToken)
+	wallet.DELETE("/tokens/:id", walletHandler.RequireScope(types.ScopeAdmin), walletHandler.RevokeToken)
+
+	admin := v1.Group("/admin", rest.RequireAdminKey(cfg.AdminKey))
+	admin.POST("/mutations/:id/reversals", walletHandler.ReverseMutation)
+	admin.POST("/fx/rates", fxHandler.SetRate)
 
 	srv := &http.Server{
 		Handler:      router,
-		Addr:         "127.0.0.1:8000",
-		WriteTimeout: 5 * time.Second,
-		ReadTimeout:  5 * time.Second,
+		Addr:         cfg.ListenAddr,
+		WriteTimeout: cfg.WriteTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
 	}
 
-	log.Println("Server started at 127.0.0.1:8000")
+	log.Println("Server started at", cfg.ListenAddr)
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("http.ListenAndServe: %v\n", err)
 	}
 
 }
 
-func migrate(db *sql.DB) {
-	createWalletsStmt := `
-		CREATE TABLE IF NOT EXISTS wallets (
-			id string primary key,
-			owned_by string not null unique,
-			token string not null unique,
-			status int not null,
-			updated_at timestamp,
-			balance real not null
-		);
-	`
-	_, err := db.Exec(createWalletsStmt)
+// newRouter returns a gin engine that logs as much as the log level asks:
+// debug adds gin's own debug output to the request log, error drops the
+// request log.
+func newRouter(logLevel string) *gin.Engine {
+	if logLevel == "debug" {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	router := gin.New()
+	if logLevel != "error" {
+		router.Use(gin.Logger())
+	}
+	router.Use(gin.Recovery())
+
+	return router
+}
+
+// database is the store selected by the db_driver setting.
+type database struct {
+	walletRepo types.WalletRepository
+	// migrator is nil for the in-memory database, which has no schema.
+	migrator *repository.Migrator
+	close    func() error
+}
+
+// openDatabase connects to SQLite, Postgres or, for demos, an in-memory
+// database that is lost on exit. The schema is left as it is; see
+// migrateUp. The SQLite DSN must keep _txlock=immediate, which the
+// repository relies on to keep concurrent requests apart.
+func openDatabase(driver, dsn string) (database, error) {
+	var (
+		newMigrator   func(*sql.DB) *repository.Migrator
+		newWalletRepo func(*sql.DB) types.WalletRepository
+	)
+	switch driver {
+	case "", "sqlite3":
+		driver, newMigrator, newWalletRepo = "sqlite3", repository.NewSQLiteMigrator, repository.NewWalletRepositiory
+	case "postgres":
+		newMigrator, newWalletRepo = repository.NewPostgresMigrator, repository.NewPostgresWalletRepository
+	case "memory":
+		log.Println("Demo mode: data is kept in memory and lost on exit")
+		return database{
+			walletRepo: repository.NewMemoryWalletRepository(),
+			close:      func() error { return nil },
+		}, nil
+	default:
+		return database{}, fmt.Errorf("unknown database driver %q", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
-		log.Fatal(err)
+		return database{}, err
+	}
+
+	return database{
+		walletRepo: newWalletRepo(db),
+		migrator:   newMigrator(db),
+		close:      db.Close,
+	}, nil
+}
+
+// runMigration runs the migrate subcommand.
+func runMigration(m *repository.Migrator, args []string) error {
+	if m == nil {
+		return errors.New("the memory database has no schema to migrate")
+	}
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+
+	switch args[0] {
+	case "up":
+		return migrateUp(m)
+	case "down":
+		migration, ok, err := m.Down(context.Background())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Println("no migration to revert")
+			return nil
+		}
+		log.Printf("reverted migration %s", migration)
+		return nil
+	case "status":
+		return printMigrationStatus(m)
+	default:
+		return errors.New("usage: migrate up|down|status")
 	}
+}
 
-	createMutationsStmt := `
-		CREATE TABLE IF NOT EXISTS mutations (
-			id string primary key,
-			reference_id string unique,
-			created_at timestamp not null,
-			created_by string not null,
-			action int not null,
-			status int not null,
-			amount real not null
-		);
-	`
-	_, err = db.Exec(createMutationsStmt)
+func migrateUp(m *repository.Migrator) error {
+	applied, err := m.Up(context.Background())
+	for _, migration := range applied {
+		log.Printf("applied migration %s", migration)
+	}
+	return err
+}
+
+func printMigrationStatus(m *repository.Migrator) error {
+	statuses, err := m.Status(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\t")
+	for _, status := range statuses {
+		name, appliedAt := status.Name, "pending"
+		if name == "" {
+			name = "(unknown to this binary)"
+		}
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t\n", status.Version, name, appliedAt)
+	}
+	return w.Flush()
+}
+
+// printConfig prints the effective configuration, secrets redacted, in the
+// format of the config file.
+func printConfig(cfg config.Config) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cfg)
+}
+
+func loadRates(fs types.FXService, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	loaded, err := fs.LoadRates(context.Background(), f)
+	log.Printf("loaded %d rates from %s", loaded, path)
+	return err
+}
+
+func printTrialBalance(ls types.LedgerService) error {
+	res, err := ls.TrialBalance(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tNAME\tBALANCE\tCURRENCY\t")
+	for _, account := range res.Accounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", account.AccountID, account.Name, account.Balance, account.Currency)
+	}
+	for _, total := range res.Totals {
+		fmt.Fprintf(w, "TOTAL\t\t%s\t%s\t\n", total.Total, total.Currency)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if !res.Balanced {
+		return errors.New("trial balance does not balance")
 	}
+	return nil
 }
@@ -0,0 +1,343 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+// Token lengths are in random bytes; a token is written as twice as many
+// hex characters.
+const (
+	minTokenLength = 16
+	maxTokenLength = 64
+
+	defaultSQLiteDSN = "wallet.db?_busy_timeout=5000&_txlock=immediate"
+	// sqliteTxLock makes SQLite transactions take the write lock when they
+	// begin, which the repository relies on to keep them apart.
+	sqliteTxLock = "immediate"
+
+	redacted = "REDACTED"
+)
+
+var (
+	logLevels = []string{"debug", "info", "error"}
+	drivers   = []string{"sqlite3", "postgres", "memory"}
+
+	dsnPassword = regexp.MustCompile(`(password=)('(?:[^'\\]|\\.)*'|\S+)`)
+)
+
+// Config is the configuration of the server and its storage.
+type Config struct {
+	ListenAddr string
+	DBDriver   string
+	// DBDSN defaults to wallet.db in the working directory for SQLite.
+	DBDSN        string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// RequestTimeout bounds the database work of a request. It must be
+	// shorter than WriteTimeout to leave room to still send the error once
+	// it has passed.
+	RequestTimeout time.Duration
+	// LogLevel is debug, info or error. The service only logs errors, so
+	// the level decides how much is logged about requests.
+	LogLevel    string
+	TokenLength int
+	AdminKey    string
+}
+
+// setting is one configuration key. It is named the same in the config
+// file, as WALLET_<KEY> in the environment and as -<key> on the command
+// line, with dashes for underscores.
+type setting struct {
+	key   string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, value string) error
+	// redact, if set, hides the secret parts of the value when the
+	// configuration is printed.
+	redact func(value string) string
+}
+
+var settings = []setting{
+	{
+		key:   "listen_addr",
+		usage: "address the server listens on",
+		get:   func(c *Config) string { return c.ListenAddr },
+		set:   func(c *Config, v string) error { c.ListenAddr = v; return nil },
+	},
+	{
+		key:   "db_driver",
+		usage: "database driver: sqlite3, postgres or memory",
+		get:   func(c *Config) string { return c.DBDriver },
+		set:   func(c *Config, v string) error { c.DBDriver = v; return nil },
+	},
+	{
+		key:    "db_dsn",
+		usage:  "database connection string",
+		get:    func(c *Config) string { return c.DBDSN },
+		set:    func(c *Config, v string) error { c.DBDSN = v; return nil },
+		redact: redactDSN,
+	},
+	{
+		key:   "read_timeout",
+		usage: "longest time to read a request",
+		get:   func(c *Config) string { return c.ReadTimeout.String() },
+		set:   func(c *Config, v string) error { return setDuration(&c.ReadTimeout, v) },
+	},
+	{
+		key:   "write_timeout",
+		usage: "longest time to write a response",
+		get:   func(c *Config) string { return c.WriteTimeout.String() },
+		set:   func(c *Config, v string) error { return setDuration(&c.WriteTimeout, v) },
+	},
+	{
+		key:   "request_timeout",
+		usage: "longest time a request may spend on the database",
+		get:   func(c *Config) string { return c.RequestTimeout.String() },
+		set:   func(c *Config, v string) error { return setDuration(&c.RequestTimeout, v) },
+	},
+	{
+		key:   "log_level",
+		usage: "debug, info or error",
+		get:   func(c *Config) string { return c.LogLevel },
+		set:   func(c *Config, v string) error { c.LogLevel = v; return nil },
+	},
+	{
+		key:   "token_length",
+		usage: "random bytes in a new token",
+		get:   func(c *Config) string { return strconv.Itoa(c.TokenLength) },
+		set: func(c *Config, v string) (err error) {
+			c.TokenLength, err = strconv.Atoi(v)
+			return err
+		},
+	},
+	{
+		key:    "admin_key",
+		usage:  "key of the /api/v1/admin endpoints, which are disabled without one",
+		get:    func(c *Config) string { return c.AdminKey },
+		set:    func(c *Config, v string) error { c.AdminKey = v; return nil },
+		redact: func(string) string { return redacted },
+	},
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() Config {
+	return Config{
+		ListenAddr:     "127.0.0.1:8000",
+		DBDriver:       "sqlite3",
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		RequestTimeout: 4 * time.Second,
+		LogLevel:       "info",
+		TokenLength:    types.DefaultTokenLength,
+	}
+}
+
+// Load builds the configuration from, each overriding the one before, the
+// defaults, the config file given by -config or WALLET_CONFIG, the
+// environment and the command-line flags, and validates it. It returns the
+// arguments left after the flags.
+func Load(args []string, getenv func(string) string) (Config, []string, error) {
+	fs := flag.NewFlagSet("wallet", flag.ContinueOnError)
+	path := fs.String("config", getenv("WALLET_CONFIG"), "config file, in JSON")
+
+	flags := map[string]string{}
+	for _, s := range settings {
+		key := s.key
+		fs.Func(strings.ReplaceAll(key, "_", "-"), s.usage, func(v string) error {
+			flags[key] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	c := Default()
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		env := "WALLET_" + strings.ToUpper(s.key)
+		if v := getenv(env); v != "" {
+			if err := s.set(&c, v); err != nil {
+				return Config{}, nil, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := flags[s.key]; ok {
+			if err := s.set(&c, v); err != nil {
+				return Config{}, nil, fmt.Errorf("-%s: %w", strings.ReplaceAll(s.key, "_", "-"), err)
+			}
+		}
+	}
+
+	if c.DBDriver == "sqlite3" {
+		switch {
+		case c.DBDSN == "":
+			c.DBDSN = defaultSQLiteDSN
+		case dsnParam(c.DBDSN, "_txlock") == "":
+			c.DBDSN = addDSNParam(c.DBDSN, "_txlock", sqliteTxLock)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+// Validate reports every setting that is out of range.
+func (c Config) Validate() error {
+	var problems []string
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("listen_addr: %v", err))
+	}
+	if !contains(drivers, c.DBDriver) {
+		problems = append(problems, fmt.Sprintf("db_driver: %q is not one of %s", c.DBDriver, strings.Join(drivers, ", ")))
+	}
+	if c.DBDriver == "postgres" && c.DBDSN == "" {
+		problems = append(problems, "db_dsn: required for postgres")
+	}
+	if c.DBDriver == "sqlite3" && dsnParam(c.DBDSN, "_txlock") != sqliteTxLock {
+		problems = append(problems, "db_dsn: _txlock must be "+sqliteTxLock+" for sqlite3")
+	}
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.RequestTimeout <= 0 {
+		problems = append(problems, "read_timeout, write_timeout and request_timeout must be positive")
+	}
+	if c.RequestTimeout >= c.WriteTimeout {
+		problems = append(problems, "request_timeout must be shorter than write_timeout, or its errors cannot be sent")
+	}
+	if !contains(logLevels, c.LogLevel) {
+		problems = append(problems, fmt.Sprintf("log_level: %q is not one of %s", c.LogLevel, strings.Join(logLevels, ", ")))
+	}
+	if c.TokenLength < minTokenLength || c.TokenLength > maxTokenLength {
+		problems = append(problems, fmt.Sprintf("token_length: must be between %d and %d", minTokenLength, maxTokenLength))
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// MarshalJSON writes the configuration in the format of the config file,
+// with the admin key and any password in the DSN redacted.
+func (c Config) MarshalJSON() ([]byte, error) {
+	values := map[string]string{}
+	for _, s := range settings {
+		v := s.get(&c)
+		if s.redact != nil && v != "" {
+			v = s.redact(v)
+		}
+		values[s.key] = v
+	}
+
+	// DSNs are full of ampersands, which are no danger outside HTML.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(values)
+	return buf.Bytes(), err
+}
+
+// helpers
+
+// loadFile reads a JSON object of settings. Values may be strings or, for
+// token_length, numbers; unknown keys are refused to catch typos.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]json.RawMessage
+	if err = json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, s := range settings {
+		raw, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		delete(values, s.key)
+
+		v := string(bytes.TrimSpace(raw))
+		if strings.HasPrefix(v, `"`) {
+			if err = json.Unmarshal(raw, &v); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, s.key, err)
+			}
+		}
+		if err = s.set(c, v); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, s.key, err)
+		}
+	}
+
+	for key := range values {
+		return fmt.Errorf("%s: unknown setting %q", path, key)
+	}
+	return nil
+}
+
+func setDuration(d *time.Duration, v string) (err error) {
+	*d, err = time.ParseDuration(v)
+	return err
+}
+
+// redactDSN hides the password of a URL or key=value DSN.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// dsnParam returns a query parameter of a SQLite DSN, such as
+// wallet.db?_txlock=immediate.
+func dsnParam(dsn, key string) string {
+	i := strings.IndexByte(dsn, '?')
+	if i < 0 {
+		return ""
+	}
+
+	params, err := url.ParseQuery(dsn[i+1:])
+	if err != nil {
+		return ""
+	}
+	return params.Get(key)
+}
+
+func addDSNParam(dsn, key, value string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + key + "=" + url.QueryEscape(value)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
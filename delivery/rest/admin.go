@@ -0,0 +1,25 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otnayrus/simple-wallet-app/utils"
+)
+
+const adminKeyHeader = "X-Admin-Key"
+
+// RequireAdminKey guards operator endpoints with a shared key. Every request
+// is refused when no key is configured.
+func RequireAdminKey(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := c.GetHeader(adminKeyHeader)
+		if key == "" || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+			utils.MakeRestResponse(c.Writer, nil, http.StatusForbidden, errAdminRequired)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
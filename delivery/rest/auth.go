@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+	"github.com/otnayrus/simple-wallet-app/utils"
+)
+
+// Authenticate resolves the token of the Authorization header, given with
+// the Token or Bearer scheme, to its wallet and stores both on the request
+// context for the handlers. Requests without a valid token are refused
+// with 401.
+func (wh *walletHandler) Authenticate(c *gin.Context) {
+	token, ok := parseAuthorization(c.GetHeader("Authorization"))
+	if !ok {
+		unauthorized(c, errInvalidCredentials)
+		return
+	}
+
+	auth, err := wh.walletService.Authenticate(c.Request.Context(), token)
+	if errors.Is(err, types.ErrTokenNotFound) {
+		unauthorized(c, errInvalidToken)
+		return
+	}
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		c.Abort()
+		return
+	}
+
+	c.Request = c.Request.WithContext(types.NewAuthContext(c.Request.Context(), auth))
+	c.Next()
+}
+
+// RequireScope refuses requests whose token does not grant the scope with
+// 403. It must run after Authenticate.
+func (wh *walletHandler) RequireScope(scope types.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requestAuth(c).Token.Scopes.Has(scope) {
+			utils.MakeRestResponse(c.Writer, nil, http.StatusForbidden, types.ErrScopeMissing)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// helpers
+
+// parseAuthorization returns the credentials of a "Token <token>" or
+// "Bearer <token>" header. Scheme names are case-insensitive.
+func parseAuthorization(header string) (string, bool) {
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !(strings.EqualFold(parts[0], "Token") || strings.EqualFold(parts[0], "Bearer")) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(parts[1])
+	if token == "" || strings.ContainsAny(token, " \t") {
+		return "", false
+	}
+
+	return token, true
+}
+
+func unauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", `Token, Bearer`)
+	utils.MakeRestResponse(c.Writer, nil, http.StatusUnauthorized, err)
+	c.Abort()
+}
+
+// requestAuth returns what Authenticate stored on the request context.
+func requestAuth(c *gin.Context) types.Auth {
+	auth, _ := types.AuthFromContext(c.Request.Context())
+	return auth
+}
@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/otnayrus/simple-wallet-app/utils"
+)
+
+// bindBody binds a JSON or form request body into req, chosen by the
+// Content-Type header, and reports whether it could. Otherwise it has
+// already responded with 415 for an unsupported content type or 400 for a
+// malformed body. The same field names are used in both encodings.
+func bindBody(c *gin.Context, req interface{}) bool {
+	b, ok := bodyBinding(c)
+	if !ok {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusUnsupportedMediaType, errUnsupportedMediaType)
+		return false
+	}
+
+	// An empty JSON body sets no fields, like an empty form.
+	if b == binding.JSON && c.Request.ContentLength == 0 {
+		return true
+	}
+
+	if err := c.ShouldBindWith(req, b); err != nil {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, invalidRequest(err))
+		return false
+	}
+
+	return true
+}
+
+// helpers
+
+func bodyBinding(c *gin.Context) (binding.Binding, bool) {
+	switch strings.ToLower(c.ContentType()) {
+	case binding.MIMEJSON:
+		return binding.JSON, true
+	case binding.MIMEPOSTForm, "":
+		return binding.Form, true
+	case binding.MIMEMultipartPOSTForm:
+		return binding.FormMultipart, true
+	}
+	return nil, false
+}
@@ -0,0 +1,32 @@
+package rest
+
+import (
+	"fmt"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
+
+// Errors found by the handlers before the request reaches a service.
+var (
+	errInvalidRequest     = apperror.Validation("invalid_request", "invalid request")
+	errInvalidAmount      = apperror.Validation("invalid_amount", "invalid amount value")
+	errInvalidExpiresIn   = apperror.Validation("invalid_expires_in", "invalid expires_in value")
+	errNotDisabled        = apperror.Validation("invalid_is_disabled", "is disabled flag is false")
+	errRecipientRequired  = apperror.Validation("missing_field", "exactly one of to_wallet_id and to_customer_xid is required")
+	errInvalidCredentials = apperror.New(apperror.KindUnauthorized, "invalid_credentials", "missing or malformed Authorization header")
+	errInvalidToken       = apperror.New(apperror.KindUnauthorized, "invalid_token", "token is unknown, expired or revoked")
+	errAdminRequired      = apperror.New(apperror.KindForbidden, "admin_required", "admin access required")
+
+	errUnsupportedMediaType = apperror.New(apperror.KindUnsupportedMediaType, "unsupported_media_type",
+		"request body must be application/json, application/x-www-form-urlencoded or multipart/form-data")
+)
+
+// errRequired reports a missing field.
+func errRequired(field string) error {
+	return apperror.Validation("missing_field", field+" is required")
+}
+
+// invalidRequest reports a request that could not be bound.
+func invalidRequest(err error) error {
+	return fmt.Errorf("%w: %v", errInvalidRequest, err)
+}
@@ -0,0 +1,91 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+	"github.com/otnayrus/simple-wallet-app/utils"
+)
+
+type fxHandler struct {
+	fxService types.FXService
+}
+
+func NewFXHandler(fs types.FXService) fxHandler {
+	return fxHandler{
+		fxService: fs,
+	}
+}
+
+func (fh *fxHandler) SetRate(c *gin.Context) {
+	var req types.SetFXRateRequest
+	if !bindBody(c, &req) {
+		return
+	}
+
+	res, err := fh.fxService.SetRate(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	utils.MakeRestResponse(c.Writer, utils.AddRateWrapper(res), http.StatusCreated, nil)
+}
+
+func (wh *walletHandler) CreateFXQuote(c *gin.Context) {
+	var req types.CreateFXQuoteRequest
+	if !bindBody(c, &req) {
+		return
+	}
+	req.Auth = requestAuth(c)
+
+	if !req.Amount.IsPositive() {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errInvalidAmount)
+		return
+	}
+
+	if req.ToWalletID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("to_wallet_id"))
+		return
+	}
+
+	res, err := wh.walletService.CreateFXQuote(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	utils.MakeRestResponse(c.Writer, utils.AddQuoteWrapper(res), http.StatusCreated, nil)
+}
+
+func (wh *walletHandler) ConvertFX(c *gin.Context) {
+	var req types.ConvertFXRequest
+	if !bindBody(c, &req) {
+		return
+	}
+	req.Auth = requestAuth(c)
+
+	if req.QuoteID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("quote_id"))
+		return
+	}
+
+	if req.ReferenceID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("reference_id"))
+		return
+	}
+
+	res, err := wh.walletService.ConvertFX(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	status := http.StatusCreated
+	if res.Replayed {
+		status = http.StatusOK
+	}
+	utils.MakeRestResponse(c.Writer, utils.AddConversionWrapper(res), status, nil)
+}
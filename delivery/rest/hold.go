@@ -0,0 +1,89 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+	"github.com/otnayrus/simple-wallet-app/utils"
+)
+
+func (wh *walletHandler) CreateHold(c *gin.Context) {
+	var req types.CreateHoldRequest
+	if !bindBody(c, &req) {
+		return
+	}
+	req.Auth = requestAuth(c)
+
+	if !req.Amount.IsPositive() {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errInvalidAmount)
+		return
+	}
+
+	if req.ReferenceID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("reference_id"))
+		return
+	}
+
+	if req.ExpiresIn < 0 {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errInvalidExpiresIn)
+		return
+	}
+
+	res, err := wh.walletService.CreateHold(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	status := http.StatusCreated
+	if res.Replayed {
+		status = http.StatusOK
+	}
+	utils.MakeRestResponse(c.Writer, utils.AddHoldWrapper(res), status, nil)
+}
+
+func (wh *walletHandler) CaptureHold(c *gin.Context) {
+	var req types.CaptureHoldRequest
+	if !bindBody(c, &req) {
+		return
+	}
+	req.Auth = requestAuth(c)
+	req.HoldID = c.Param("id")
+
+	if req.Amount.Minor < 0 {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errInvalidAmount)
+		return
+	}
+
+	if req.ReferenceID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("reference_id"))
+		return
+	}
+
+	res, err := wh.walletService.CaptureHold(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	status := http.StatusCreated
+	if res.Withdrawal.Replayed {
+		status = http.StatusOK
+	}
+	utils.MakeRestResponse(c.Writer, res, status, nil)
+}
+
+func (wh *walletHandler) VoidHold(c *gin.Context) {
+	res, err := wh.walletService.VoidHold(c.Request.Context(), types.VoidHoldRequest{
+		Auth:   requestAuth(c),
+		HoldID: c.Param("id"),
+	})
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	utils.MakeRestResponse(c.Writer, utils.AddHoldWrapper(res), http.StatusOK, nil)
+}
@@ -0,0 +1,40 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+	"github.com/otnayrus/simple-wallet-app/utils"
+)
+
+func (wh *walletHandler) ReverseMutation(c *gin.Context) {
+	var req types.ReverseMutationRequest
+	if !bindBody(c, &req) {
+		return
+	}
+	req.MutationID = c.Param("id")
+
+	if req.Amount.Minor < 0 {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errInvalidAmount)
+		return
+	}
+
+	if req.ReferenceID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("reference_id"))
+		return
+	}
+
+	res, err := wh.walletService.ReverseMutation(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	status := http.StatusCreated
+	if res.Replayed {
+		status = http.StatusOK
+	}
+	utils.MakeRestResponse(c.Writer, utils.AddReversalWrapper(res), status, nil)
+}
@@ -0,0 +1,65 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+	"github.com/otnayrus/simple-wallet-app/utils"
+)
+
+func (wh *walletHandler) ListTokens(c *gin.Context) {
+	res, err := wh.walletService.ListTokens(c.Request.Context(), types.ListTokensRequest{Auth: requestAuth(c)})
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	utils.MakeRestResponse(c.Writer, res, http.StatusOK, nil)
+}
+
+func (wh *walletHandler) CreateToken(c *gin.Context) {
+	var req types.CreateTokenRequest
+	if !bindBody(c, &req) {
+		return
+	}
+	req.Auth = requestAuth(c)
+
+	res, err := wh.walletService.CreateToken(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	utils.MakeRestResponse(c.Writer, utils.AddTokenWrapper(res), http.StatusCreated, nil)
+}
+
+func (wh *walletHandler) RotateToken(c *gin.Context) {
+	var req types.RotateTokenRequest
+	if !bindBody(c, &req) {
+		return
+	}
+	req.Auth = requestAuth(c)
+
+	res, err := wh.walletService.RotateToken(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	utils.MakeRestResponse(c.Writer, utils.AddTokenWrapper(res), http.StatusCreated, nil)
+}
+
+func (wh *walletHandler) RevokeToken(c *gin.Context) {
+	res, err := wh.walletService.RevokeToken(c.Request.Context(), types.RevokeTokenRequest{
+		Auth:    requestAuth(c),
+		TokenID: c.Param("id"),
+	})
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+
+	utils.MakeRestResponse(c.Writer, utils.AddTokenWrapper(res), http.StatusOK, nil)
+}
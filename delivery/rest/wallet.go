@@ -1,12 +1,11 @@
 package rest
 
 import (
-	"errors"
 	"log"
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
 	types "github.com/otnayrus/simple-wallet-app/types/wallet"
 	"github.com/otnayrus/simple-wallet-app/utils"
 )
@@ -24,14 +23,13 @@ func NewWalletHandler(ws types.WalletService) walletHandler {
 func (wh *walletHandler) Initialize(c *gin.Context) {
 	var req types.InitializeRequest
 
-	if err := c.Bind(&req); err != nil {
-		log.Println("fail to decode request body")
+	if !bindBody(c, &req) {
 		return
 	}
 
-	res, err := wh.walletService.Initialize(req)
+	res, err := wh.walletService.Initialize(c.Request.Context(), req)
 	if err != nil {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusInternalServerError, err)
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
 		return
 	}
 
@@ -39,178 +37,183 @@ func (wh *walletHandler) Initialize(c *gin.Context) {
 }
 
 func (wh *walletHandler) Enable(c *gin.Context) {
-	var header types.WalletRequestHeader
-
-	if err := c.BindHeader(&header); err != nil {
-		log.Println("fail to decode request header")
+	res, err := wh.walletService.Enable(c.Request.Context(), types.EnableRequest{Auth: requestAuth(c)})
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
 		return
 	}
 
-	split := strings.Split(header.Authorization, " ")
-	if len(split) != 2 && split[0] != "Token" {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("invalid request header"))
-		return
-	}
-	token := split[1]
+	utils.MakeRestResponse(c.Writer, utils.AddWalletWrapper(res), http.StatusCreated, nil)
+}
 
-	res, err := wh.walletService.Enable(types.EnableRequest{Token: token})
+func (wh *walletHandler) ViewBalance(c *gin.Context) {
+	res, err := wh.walletService.ViewBalance(c.Request.Context(), types.ViewBalanceRequest{Auth: requestAuth(c)})
 	if err != nil {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusInternalServerError, err)
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
 		return
 	}
 
-	utils.MakeRestResponse(c.Writer, utils.AddWalletWrapper(res), http.StatusCreated, nil)
+	utils.MakeRestResponse(c.Writer, utils.AddWalletWrapper(res), http.StatusOK, nil)
 }
 
-func (wh *walletHandler) ViewBalance(c *gin.Context) {
-	var header types.WalletRequestHeader
-
-	if err := c.BindHeader(&header); err != nil {
-		log.Println("fail to decode request header")
+func (wh *walletHandler) Disable(c *gin.Context) {
+	var req types.DisableRequest
+	if !bindBody(c, &req) {
 		return
 	}
-
-	split := strings.Split(header.Authorization, " ")
-	if len(split) != 2 && split[0] != "Token" {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("invalid request header"))
+	if !req.IsDisabled {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errNotDisabled)
 		return
 	}
-	token := split[1]
 
-	res, err := wh.walletService.ViewBalance(types.ViewBalanceRequest{Token: token})
+	res, err := wh.walletService.Disable(c.Request.Context(), types.DisableRequest{Auth: requestAuth(c)})
 	if err != nil {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusInternalServerError, err)
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
 		return
 	}
 
-	utils.MakeRestResponse(c.Writer, utils.AddWalletWrapper(res), http.StatusOK, nil)
+	utils.MakeRestResponse(c.Writer, utils.AddWalletWrapper(res), http.StatusCreated, nil)
 }
 
-func (wh *walletHandler) Disable(c *gin.Context) {
-	var header types.WalletRequestHeader
-
-	if err := c.BindHeader(&header); err != nil {
-		log.Println("fail to decode request header")
+func (wh *walletHandler) Deposit(c *gin.Context) {
+	var req types.DepositRequest
+	if !bindBody(c, &req) {
 		return
 	}
+	req.Auth = requestAuth(c)
 
-	split := strings.Split(header.Authorization, " ")
-	if len(split) != 2 && split[0] != "Token" {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("invalid request header"))
+	if !req.Amount.IsPositive() {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errInvalidAmount)
 		return
 	}
-	token := split[1]
 
-	var req types.DisableRequest
-	if err := c.ShouldBind(&req); err != nil {
-		log.Println("fail to decode request body")
-		return
-	}
-	if !req.IsDisabled {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("is disabled flag is false"))
+	if req.ReferenceID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("reference_id"))
 		return
 	}
 
-	res, err := wh.walletService.Disable(types.DisableRequest{Token: token})
+	res, err := wh.walletService.Deposit(c.Request.Context(), req)
 	if err != nil {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusInternalServerError, err)
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
 		return
 	}
 
-	utils.MakeRestResponse(c.Writer, utils.AddWalletWrapper(res), http.StatusCreated, nil)
-}
-
-func (wh *walletHandler) Deposit(c *gin.Context) {
-	var header types.WalletRequestHeader
-	if err := c.BindHeader(&header); err != nil {
-		log.Println("fail to decode request header")
-		return
+	status := http.StatusCreated
+	if res.Replayed {
+		status = http.StatusOK
 	}
+	utils.MakeRestResponse(c.Writer, utils.AddDepositWrapper(res), status, nil)
+}
 
-	split := strings.Split(header.Authorization, " ")
-	if len(split) != 2 && split[0] != "Token" {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("invalid request header"))
+func (wh *walletHandler) Withdraw(c *gin.Context) {
+	var req types.WithdrawRequest
+	if !bindBody(c, &req) {
 		return
 	}
-	token := split[1]
+	req.Auth = requestAuth(c)
 
-	var req types.DepositRequest
-	if err := c.Bind(&req); err != nil {
-		log.Println("fail to decode request body")
+	if !req.Amount.IsPositive() {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errInvalidAmount)
 		return
 	}
-	req.Token = token
 
-	if req.Amount <= 0 {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("invalid amount value"))
+	if req.ReferenceID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("reference_id"))
 		return
 	}
 
-	res, err := wh.walletService.Deposit(req)
+	res, err := wh.walletService.Withdraw(c.Request.Context(), req)
 	if err != nil {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusInternalServerError, err)
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
 		return
 	}
 
-	utils.MakeRestResponse(c.Writer, utils.AddDepositWrapper(res), http.StatusCreated, nil)
+	status := http.StatusCreated
+	if res.Replayed {
+		status = http.StatusOK
+	}
+	utils.MakeRestResponse(c.Writer, utils.AddWithdrawWrapper(res), status, nil)
 }
 
-func (wh *walletHandler) Withdraw(c *gin.Context) {
-	var header types.WalletRequestHeader
-	if err := c.BindHeader(&header); err != nil {
-		log.Println("fail to decode request header")
+func (wh *walletHandler) Transfer(c *gin.Context) {
+	var req types.TransferRequest
+	if !bindBody(c, &req) {
 		return
 	}
+	req.Auth = requestAuth(c)
 
-	split := strings.Split(header.Authorization, " ")
-	if len(split) != 2 && split[0] != "Token" {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("invalid request header"))
+	if !req.Amount.IsPositive() {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errInvalidAmount)
 		return
 	}
-	token := split[1]
 
-	var req types.WithdrawRequest
-	if err := c.Bind(&req); err != nil {
-		log.Println("fail to decode request body")
+	if req.ReferenceID == "" {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRequired("reference_id"))
 		return
 	}
-	req.Token = token
 
-	if req.Amount <= 0 {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("invalid amount value"))
+	if (req.ToWalletID == "") == (req.ToCustomerID == "") {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errRecipientRequired)
 		return
 	}
 
-	res, err := wh.walletService.Withdraw(req)
+	res, err := wh.walletService.Transfer(c.Request.Context(), req)
 	if err != nil {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusInternalServerError, err)
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
 		return
 	}
 
-	utils.MakeRestResponse(c.Writer, utils.AddWithdrawWrapper(res), http.StatusCreated, nil)
+	status := http.StatusCreated
+	if res.Replayed {
+		status = http.StatusOK
+	}
+	utils.MakeRestResponse(c.Writer, utils.AddTransferWrapper(res), status, nil)
 }
 
 func (wh *walletHandler) GetMutationList(c *gin.Context) {
-	var header types.WalletRequestHeader
-	if err := c.BindHeader(&header); err != nil {
-		log.Println("fail to decode request header")
+	var req types.MutationListRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, invalidRequest(err))
 		return
 	}
+	req.Auth = requestAuth(c)
 
-	split := strings.Split(header.Authorization, " ")
-	if len(split) != 2 && split[0] != "Token" {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, errors.New("invalid request header"))
+	res, err := wh.walletService.ListMutation(c.Request.Context(), req)
+	if err != nil {
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
 		return
 	}
-	token := split[1]
 
-	res, err := wh.walletService.ListMutation(types.MutationListRequest{
-		Token: token,
-	})
-	if err != nil {
-		utils.MakeRestResponse(c.Writer, nil, http.StatusInternalServerError, err)
+	utils.MakeRestResponse(c.Writer, res, http.StatusOK, nil)
+}
+
+func (wh *walletHandler) ExportMutationList(c *gin.Context) {
+	req := types.MutationExportRequest{
+		Format: types.MutationExportFormatCSV,
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, invalidRequest(err))
 		return
 	}
+	req.Auth = requestAuth(c)
 
-	utils.MakeRestResponse(c.Writer, res, http.StatusOK, nil)
+	contentType, ok := req.Format.ContentType()
+	if !ok {
+		utils.MakeRestResponse(c.Writer, nil, http.StatusBadRequest, types.ErrUnknownExportFormat)
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="transactions.`+string(req.Format)+`"`)
+
+	err := wh.walletService.ExportMutation(c.Request.Context(), req, c.Writer)
+	if err != nil && !c.Writer.Written() {
+		// Nothing was streamed yet, so the error can still be reported.
+		c.Writer.Header().Del("Content-Disposition")
+		utils.MakeRestResponse(c.Writer, nil, apperror.Status(err), err)
+		return
+	}
+	if err != nil {
+		log.Println("walletHandler.ExportMutationList", err)
+	}
 }
@@ -0,0 +1,212 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otnayrus/simple-wallet-app/repository"
+	"github.com/otnayrus/simple-wallet-app/service"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func TestAuthenticateRefusesMissingAndUnknownTokens(t *testing.T) {
+	router := newTestRouter()
+
+	tests := []struct {
+		token    string
+		wantCode string
+	}{
+		{"", "invalid_credentials"},
+		{"not-a-token", "invalid_token"},
+	}
+	for _, tt := range tests {
+		res := serve(router, http.MethodPost, "/api/v1/wallet/deposits", tt.token, `{"amount":"1","reference_id":"r"}`)
+		if res.Code != http.StatusUnauthorized || errorCode(t, res) != tt.wantCode {
+			t.Errorf("token %q: got %d %s, want 401 %s", tt.token, res.Code, res.Body, tt.wantCode)
+		}
+		if res.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q: no WWW-Authenticate header", tt.token)
+		}
+	}
+}
+
+func TestDepositStatusTellsNewFromReplayed(t *testing.T) {
+	router := newTestRouter()
+	token := initEnabledWallet(t, router)
+
+	tests := []struct {
+		body       string
+		wantStatus int
+	}{
+		{`{"amount":"100.50","reference_id":"deposit-1"}`, http.StatusCreated},
+		{`{"amount":"100.50","reference_id":"deposit-1"}`, http.StatusOK},
+		{`{"amount":"200","reference_id":"deposit-1"}`, http.StatusConflict},
+		{`{"amount":"-1","reference_id":"deposit-2"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		res := serve(router, http.MethodPost, "/api/v1/wallet/deposits", token, tt.body)
+		if res.Code != tt.wantStatus {
+			t.Errorf("deposit %s: got %d %s, want %d", tt.body, res.Code, res.Body, tt.wantStatus)
+		}
+	}
+}
+
+func TestWithdrawStatusTellsNewFromReplayed(t *testing.T) {
+	router := newTestRouter()
+	token := initEnabledWallet(t, router)
+	if res := serve(router, http.MethodPost, "/api/v1/wallet/deposits", token, `{"amount":"100","reference_id":"deposit-1"}`); res.Code != http.StatusCreated {
+		t.Fatalf("deposit: got %d %s", res.Code, res.Body)
+	}
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{`{"amount":"40","reference_id":"withdraw-1"}`, http.StatusCreated, ""},
+		{`{"amount":"40","reference_id":"withdraw-1"}`, http.StatusOK, ""},
+		{`{"amount":"41","reference_id":"withdraw-1"}`, http.StatusConflict, "duplicate_reference"},
+		{`{"amount":"40","reference_id":"deposit-1"}`, http.StatusConflict, "duplicate_reference"},
+	}
+	for _, tt := range tests {
+		res := serve(router, http.MethodPost, "/api/v1/wallet/withdrawals", token, tt.body)
+		if res.Code != tt.wantStatus || (tt.wantCode != "" && errorCode(t, res) != tt.wantCode) {
+			t.Errorf("withdraw %s: got %d %s, want %d %s", tt.body, res.Code, res.Body, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestRequireScopeRefusesTokensWithoutIt(t *testing.T) {
+	router := newTestRouter()
+	token := initEnabledWallet(t, router)
+
+	res := serve(router, http.MethodPost, "/api/v1/wallet/tokens", token, `{"scopes":["deposit"]}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create token: got %d %s", res.Code, res.Body)
+	}
+	var body struct {
+		Data struct {
+			Token types.TokenResponse `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	depositOnly := body.Data.Token.Token
+
+	if res = serve(router, http.MethodPost, "/api/v1/wallet/deposits", depositOnly, `{"amount":"100","reference_id":"deposit-1"}`); res.Code != http.StatusCreated {
+		t.Errorf("deposit with the deposit scope: got %d %s, want 201", res.Code, res.Body)
+	}
+
+	for _, path := range []string{"/api/v1/wallet/withdrawals", "/api/v1/wallet/tokens"} {
+		res = serve(router, http.MethodPost, path, depositOnly, `{"amount":"100","reference_id":"withdraw-1"}`)
+		if res.Code != http.StatusForbidden || errorCode(t, res) != "scope_missing" {
+			t.Errorf("POST %s with only the deposit scope: got %d %s, want 403 scope_missing", path, res.Code, res.Body)
+		}
+	}
+}
+
+func TestBindBodyRefusesUnsupportedAndMalformedBodies(t *testing.T) {
+	router := newTestRouter()
+	token := initEnabledWallet(t, router)
+
+	tests := []struct {
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+	}{
+		{"application/x-www-form-urlencoded", "amount=100&reference_id=deposit-1", http.StatusCreated, ""},
+		{"application/json; charset=utf-8", `{"amount":"100","reference_id":"deposit-2"}`, http.StatusCreated, ""},
+		{"text/plain", "amount=100&reference_id=deposit-3", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"application/xml", "<deposit/>", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"application/json", `{"amount":"100",`, http.StatusBadRequest, "invalid_request"},
+		{"application/json", `{"amount":true,"reference_id":"deposit-4"}`, http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet/deposits", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		req.Header.Set("Authorization", "Token "+token)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		if res.Code != tt.wantStatus || (tt.wantCode != "" && errorCode(t, res) != tt.wantCode) {
+			t.Errorf("%s body %s: got %d %s, want %d %s", tt.contentType, tt.body, res.Code, res.Body, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+// newTestRouter serves the routes under test the way app/main.go does, on
+// an in-memory database.
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	wh := NewWalletHandler(service.NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength))
+
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	v1.POST("/init", wh.Initialize)
+	wallet := v1.Group("/wallet", wh.Authenticate)
+	wallet.POST("", wh.RequireScope(types.ScopeAdmin), wh.Enable)
+	wallet.POST("/deposits", wh.RequireScope(types.ScopeDeposit), wh.Deposit)
+	wallet.POST("/withdrawals", wh.RequireScope(types.ScopeWithdraw), wh.Withdraw)
+	wallet.POST("/tokens", wh.RequireScope(types.ScopeAdmin), wh.CreateToken)
+
+	return router
+}
+
+func initEnabledWallet(t *testing.T, router *gin.Engine) string {
+	t.Helper()
+
+	res := serve(router, http.MethodPost, "/api/v1/init", "", `{"customer_xid":"alice"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("init: got %d %s", res.Code, res.Body)
+	}
+
+	var body struct {
+		Data types.InitializeResponse `json:"data"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if res = serve(router, http.MethodPost, "/api/v1/wallet", body.Data.Token, ""); res.Code != http.StatusCreated {
+		t.Fatalf("enable: got %d %s", res.Code, res.Body)
+	}
+
+	return body.Data.Token
+}
+
+func serve(router *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func errorCode(t *testing.T, res *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var body struct {
+		Data struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	return body.Data.Error.Code
+}
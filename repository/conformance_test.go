@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+// The tests of this package are a conformance suite that every
+// WalletRepository must pass. Each runs on a fresh, migrated database of
+// every backend: SQLite and memory always, and Postgres when
+// WALLET_TEST_POSTGRES_DSN names a database the tests may create schemas
+// in.
+var conformanceTests = []struct {
+	name string
+	test func(t *testing.T, wr types.WalletRepository)
+}{
+	{"MutateConcurrentWithdrawalsNeverOverdraw", testMutateConcurrentWithdrawalsNeverOverdraw},
+	{"MutateConcurrentDepositsAreNotLost", testMutateConcurrentDepositsAreNotLost},
+	{"MutateRejectsInactiveWallet", testMutateRejectsInactiveWallet},
+	{"AddWalletBalanceByIDRejectsOverflowingBalance", testAddWalletBalanceByIDRejectsOverflowingBalance},
+	{"WithinTransactionRollsBackEveryWrite", testWithinTransactionRollsBackEveryWrite},
+	{"AddReversedAmountNeverExceedsMutationAmount", testAddReversedAmountNeverExceedsMutationAmount},
+	{"ListMutationPagesWithoutGapsOrRepeats", testListMutationPagesWithoutGapsOrRepeats},
+	{"CreateMutationRejectsDuplicateReferenceID", testCreateMutationRejectsDuplicateReferenceID},
+	{"ConcurrentRetriesOfADepositApplyItOnce", testConcurrentRetriesOfADepositApplyItOnce},
+	{"ConcurrentOppositeTransfersDoNotDeadlock", testConcurrentOppositeTransfersDoNotDeadlock},
+	{"HeldFundsCannotBeWithdrawnUntilReleased", testHeldFundsCannotBeWithdrawnUntilReleased},
+	{"CreateHoldRejectsDuplicateReferenceID", testCreateHoldRejectsDuplicateReferenceID},
+	{"MutatePostsBalancedJournalEntries", testMutatePostsBalancedJournalEntries},
+	{"PostJournalEntryRejectsUnbalancedEntry", testPostJournalEntryRejectsUnbalancedEntry},
+	{"GetByTokenOnlyAcceptsValidTokens", testGetByTokenOnlyAcceptsValidTokens},
+	{"GetTokenVerifiesHashAmongSharedPrefix", testGetTokenVerifiesHashAmongSharedPrefix},
+	{"TokenScopesRoundTrip", testTokenScopesRoundTrip},
+	{"GetRateReturnsRateInEffectAtTime", testGetRateReturnsRateInEffectAtTime},
+	{"UseQuoteSucceedsOnce", testUseQuoteSucceedsOnce},
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	runConformance(t, newSQLiteTestRepository)
+}
+
+func TestMemoryConformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) types.WalletRepository {
+		return NewMemoryWalletRepository()
+	})
+}
+
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv("WALLET_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("WALLET_TEST_POSTGRES_DSN is not set")
+	}
+
+	runConformance(t, func(t *testing.T) types.WalletRepository {
+		return newPostgresTestRepository(t, dsn)
+	})
+}
+
+func runConformance(t *testing.T, newRepository func(t *testing.T) types.WalletRepository) {
+	for _, ct := range conformanceTests {
+		ct := ct
+		t.Run(ct.name, func(t *testing.T) {
+			ct.test(t, newRepository(t))
+		})
+	}
+}
+
+func newSQLiteTestRepository(t *testing.T) types.WalletRepository {
+	t.Helper()
+
+	db := openSQLiteTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewWalletRepositiory(db)
+}
+
+// newPostgresTestRepository migrates a schema of its own, dropped when the
+// test ends, so tests never see each other's rows.
+func newPostgresTestRepository(t *testing.T, dsn string) types.WalletRepository {
+	t.Helper()
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		if dsn, err = pq.ParseURL(dsn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "wallet_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err = admin.Exec("CREATE SCHEMA " + schema + ";"); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE;")
+		admin.Close()
+	})
+
+	db, err := sql.Open("postgres", dsn+" search_path="+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = MigratePostgres(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewPostgresWalletRepository(db)
+}
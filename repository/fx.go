@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+type fxRepository struct {
+	db dbtx
+}
+
+const (
+	createFXRateQuery = `
+		INSERT INTO fx_rates (base, quote, rate, effective_at, created_at)
+		VALUES ($1, $2, $3, $4, $5);
+	`
+
+	// Rates loaded later win over earlier ones with the same effective
+	// time, so a correction can be loaded without deleting anything.
+	getFXRateQuery = `
+		SELECT base, quote, rate, effective_at, created_at
+		FROM fx_rates
+		WHERE base = $1 AND quote = $2 AND effective_at <= $3
+		ORDER BY effective_at DESC, id DESC
+		LIMIT 1;
+	`
+
+	createFXQuoteQuery = `
+		INSERT INTO fx_quotes (id, wallet_id, to_wallet_id, from_currency, to_currency, rate, amount, converted_amount, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+	`
+
+	getFXQuoteByIDQuery = `
+		SELECT id, wallet_id, to_wallet_id, from_currency, to_currency, rate, amount, converted_amount, created_at, expires_at, used_at
+		FROM fx_quotes
+		WHERE id = $1;
+	`
+
+	useFXQuoteQuery = `
+		UPDATE fx_quotes
+		SET used_at = $1
+		WHERE id = $2 AND used_at IS NULL;
+	`
+)
+
+// NewFXRepository returns an FX repository outside of any wallet
+// transaction, for managing rates.
+func NewFXRepository(db *sql.DB) types.FXRepository {
+	return &fxRepository{
+		db: db,
+	}
+}
+
+// CreateRate stores times in UTC like every other timestamp, so they
+// compare correctly as SQLite text.
+func (fr *fxRepository) CreateRate(ctx context.Context, req types.FXRate) error {
+	_, err := fr.db.ExecContext(ctx,
+		createFXRateQuery,
+		req.Base,
+		req.Quote,
+		req.Rate,
+		req.EffectiveAt.UTC(),
+		req.CreatedAt.UTC(),
+	)
+
+	return err
+}
+
+func (fr *fxRepository) GetRate(ctx context.Context, base, quote string, at time.Time) (types.FXRate, error) {
+	var data types.FXRate
+	err := fr.db.QueryRowContext(ctx, getFXRateQuery, base, quote, at.UTC()).Scan(
+		&data.Base,
+		&data.Quote,
+		&data.Rate,
+		&data.EffectiveAt,
+		&data.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return types.FXRate{}, types.ErrRateNotFound
+	}
+
+	return data, err
+}
+
+func (fr *fxRepository) CreateQuote(ctx context.Context, req types.FXQuote) error {
+	_, err := fr.db.ExecContext(ctx,
+		createFXQuoteQuery,
+		req.ID,
+		req.WalletID,
+		req.ToWalletID,
+		req.FromCurrency,
+		req.ToCurrency,
+		req.Rate,
+		req.Amount,
+		req.ConvertedAmount,
+		req.CreatedAt.UTC(),
+		req.ExpiresAt.UTC(),
+	)
+
+	return err
+}
+
+func (fr *fxRepository) GetQuote(ctx context.Context, id string) (types.FXQuote, error) {
+	var data types.FXQuote
+	err := fr.db.QueryRowContext(ctx, getFXQuoteByIDQuery, id).Scan(
+		&data.ID,
+		&data.WalletID,
+		&data.ToWalletID,
+		&data.FromCurrency,
+		&data.ToCurrency,
+		&data.Rate,
+		&data.Amount,
+		&data.ConvertedAmount,
+		&data.CreatedAt,
+		&data.ExpiresAt,
+		&data.UsedAt,
+	)
+	if err == sql.ErrNoRows {
+		return types.FXQuote{}, types.ErrQuoteNotFound
+	}
+
+	data.Amount.Exponent = types.CurrencyExponent(data.FromCurrency)
+	data.ConvertedAmount.Exponent = types.CurrencyExponent(data.ToCurrency)
+	return data, err
+}
+
+func (fr *fxRepository) UseQuote(ctx context.Context, id string, at time.Time) error {
+	res, err := fr.db.ExecContext(ctx, useFXQuoteQuery, at.UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return types.ErrQuoteUsed
+	}
+
+	return nil
+}
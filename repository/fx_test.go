@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func testGetRateReturnsRateInEffectAtTime(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	fr := wr.FX()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, rate := range []string{"15000", "15500.5", "16000"} {
+		r, err := types.ParseRate(rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = fr.CreateRate(ctx, types.FXRate{
+			Base:        "USD",
+			Quote:       "IDR",
+			Rate:        r,
+			EffectiveAt: start.AddDate(0, 0, i),
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{start, "15000"},
+		{start.Add(36 * time.Hour), "15500.5"},
+		{start.AddDate(1, 0, 0), "16000"},
+	}
+	for _, tt := range tests {
+		got, err := fr.GetRate(ctx, "USD", "IDR", tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Rate.String() != tt.want {
+			t.Errorf("rate at %v = %s, want %s", tt.at, got.Rate, tt.want)
+		}
+	}
+
+	if _, err := fr.GetRate(ctx, "USD", "IDR", start.Add(-time.Second)); err != types.ErrRateNotFound {
+		t.Errorf("rate before the first one: got %v, want %v", err, types.ErrRateNotFound)
+	}
+	if _, err := fr.GetRate(ctx, "IDR", "USD", start); err != types.ErrRateNotFound {
+		t.Errorf("inverse pair: got %v, want %v", err, types.ErrRateNotFound)
+	}
+}
+
+func testUseQuoteSucceedsOnce(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	from := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+	to := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	now := time.Now()
+	quote := types.FXQuote{
+		ID:              uuid.NewString(),
+		WalletID:        from.ID,
+		ToWalletID:      to.ID,
+		FromCurrency:    "IDR",
+		ToCurrency:      "USD",
+		Rate:            types.Rate{Units: 639, Scale: 7},
+		Amount:          types.NewMoney(50000000, 2),
+		ConvertedAmount: types.NewMoney(3195, 2),
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(types.DefaultFXQuoteDuration),
+	}
+	if err := wr.FX().CreateQuote(ctx, quote); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wr.FX().UseQuote(ctx, quote.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.FX().UseQuote(ctx, quote.ID, now); err != types.ErrQuoteUsed {
+		t.Fatalf("second use: got %v, want %v", err, types.ErrQuoteUsed)
+	}
+
+	got, err := wr.FX().GetQuote(ctx, quote.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.UsedAt.Valid || got.Rate != quote.Rate || got.ConvertedAmount.Cmp(quote.ConvertedAmount) != 0 {
+		t.Errorf("stored quote = %+v, want %+v used", got, quote)
+	}
+}
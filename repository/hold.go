@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+const (
+	addWalletHeldByIDQuery = `
+		UPDATE wallets
+		SET
+			held = held + $1,
+			updated_at = $2
+		WHERE
+			id = $3
+			AND status = $4
+			AND balance - held - $1 >= 0;
+	`
+
+	subtractWalletHeldByIDQuery = `
+		UPDATE wallets
+		SET
+			held = held - $1,
+			updated_at = $2
+		WHERE
+			id = $3;
+	`
+
+	getWalletStatusByIDQuery = `
+		SELECT status
+		FROM wallets
+		WHERE id = $1;
+	`
+
+	createHoldQuery = `
+		INSERT INTO holds (id, wallet_id, reference_id, status, amount, captured_amount, currency, mutation_id, created_at, expires_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+	`
+
+	getHoldByIDQuery = `
+		SELECT id, wallet_id, reference_id, status, amount, captured_amount, currency, mutation_id, created_at, expires_at, updated_at
+		FROM holds
+		WHERE id = $1;
+	`
+
+	getHoldByReferenceIDQuery = `
+		SELECT id, wallet_id, reference_id, status, amount, captured_amount, currency, mutation_id, created_at, expires_at, updated_at
+		FROM holds
+		WHERE reference_id = $1;
+	`
+
+	getActiveHoldListQuery = `
+		SELECT id, wallet_id, reference_id, status, amount, captured_amount, currency, mutation_id, created_at, expires_at, updated_at
+		FROM holds
+		WHERE wallet_id = $1 AND status = $2
+		ORDER BY expires_at;
+	`
+
+	releaseHoldQuery = `
+		UPDATE holds
+		SET
+			status = $1,
+			captured_amount = $2,
+			mutation_id = $3,
+			updated_at = $4
+		WHERE
+			id = $5
+			AND status = $6
+		RETURNING id, wallet_id, reference_id, status, amount, captured_amount, currency, mutation_id, created_at, expires_at, updated_at;
+	`
+)
+
+func (wr *walletRepository) CreateHold(ctx context.Context, req types.Hold) error {
+	return wr.withinTransaction(ctx, func(tr *walletRepository) error {
+		if err := tr.lockWallet(ctx, req.WalletID); err != nil {
+			return err
+		}
+
+		res, err := tr.db.ExecContext(ctx,
+			addWalletHeldByIDQuery,
+			req.Amount,
+			time.Now().UTC(),
+			req.WalletID,
+			types.StatusActive,
+		)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return tr.balanceUpdateFailureReason(ctx, getWalletStatusByIDQuery, req.WalletID)
+		}
+
+		_, err = tr.db.ExecContext(ctx,
+			createHoldQuery,
+			req.ID,
+			req.WalletID,
+			req.ReferenceID,
+			req.Status,
+			req.Amount,
+			req.CapturedAmount,
+			req.Currency,
+			req.MutationID,
+			req.CreatedAt.UTC(),
+			req.ExpiresAt.UTC(),
+			req.UpdatedAt,
+		)
+		if isUniqueViolation(err, "holds", "reference_id") {
+			return duplicateReference(req.ReferenceID)
+		}
+
+		return err
+	})
+}
+
+func (wr *walletRepository) GetHold(ctx context.Context, id string) (types.Hold, error) {
+	var data types.Hold
+	err := scanHold(wr.db.QueryRowContext(ctx, getHoldByIDQuery, id), &data)
+
+	return data, err
+}
+
+func (wr *walletRepository) GetHoldByReferenceID(ctx context.Context, referenceID string) (types.Hold, error) {
+	var data types.Hold
+	err := scanHold(wr.db.QueryRowContext(ctx, getHoldByReferenceIDQuery, referenceID), &data)
+
+	return data, err
+}
+
+func (wr *walletRepository) ListActiveHolds(ctx context.Context, walletID string) ([]types.Hold, error) {
+	rows, err := wr.db.QueryContext(ctx, getActiveHoldListQuery, walletID, types.HoldStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []types.Hold
+	for rows.Next() {
+		var hold types.Hold
+		if err := scanHold(rows, &hold); err != nil {
+			return nil, err
+		}
+
+		holds = append(holds, hold)
+	}
+
+	return holds, rows.Err()
+}
+
+func (wr *walletRepository) ReleaseHold(ctx context.Context, id string, status types.HoldStatus, captured types.Money, mutationID string) (types.Hold, error) {
+	var data types.Hold
+	err := wr.withinTransaction(ctx, func(tr *walletRepository) error {
+		now := time.Now().UTC()
+		err := scanHold(tr.db.QueryRowContext(ctx,
+			releaseHoldQuery,
+			status,
+			captured,
+			mutationID,
+			now,
+			id,
+			types.HoldStatusActive,
+		), &data)
+		if err != nil {
+			return err
+		}
+
+		_, err = tr.db.ExecContext(ctx,
+			subtractWalletHeldByIDQuery,
+			data.Amount,
+			now,
+			data.WalletID,
+		)
+
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return types.Hold{}, types.ErrHoldNotActive
+	}
+
+	return data, err
+}
+
+// helpers
+
+func scanHold(row rowScanner, data *types.Hold) error {
+	err := row.Scan(
+		&data.ID,
+		&data.WalletID,
+		&data.ReferenceID,
+		&data.Status,
+		&data.Amount,
+		&data.CapturedAmount,
+		&data.Currency,
+		&data.MutationID,
+		&data.CreatedAt,
+		&data.ExpiresAt,
+		&data.UpdatedAt,
+	)
+
+	exponent := types.CurrencyExponent(data.Currency)
+	data.Amount.Exponent, data.CapturedAmount.Exponent = exponent, exponent
+	return err
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func testHeldFundsCannotBeWithdrawnUntilReleased(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(10000, types.DefaultCurrencyExponent))
+
+	hold := types.Hold{
+		ID:             uuid.NewString(),
+		WalletID:       wallet.ID,
+		ReferenceID:    uuid.NewString(),
+		Status:         int(types.HoldStatusActive),
+		Amount:         types.NewMoney(7000, types.DefaultCurrencyExponent),
+		CapturedAmount: types.NewMoney(0, types.DefaultCurrencyExponent),
+		CreatedAt:      time.Now(),
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}
+	if err := wr.CreateHold(ctx, hold); err != nil {
+		t.Fatal(err)
+	}
+
+	withdrawal := newMutation(wallet, types.MutationActionWithdraw, types.NewMoney(5000, types.DefaultCurrencyExponent))
+	if _, err := wr.Mutate(ctx, withdrawal); err != types.ErrInsufficientFunds {
+		t.Fatalf("got error %v, want %v", err, types.ErrInsufficientFunds)
+	}
+
+	if _, err := wr.ReleaseHold(ctx, hold.ID, types.HoldStatusVoided, types.NewMoney(0, types.DefaultCurrencyExponent), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wr.ReleaseHold(ctx, hold.ID, types.HoldStatusVoided, types.NewMoney(0, types.DefaultCurrencyExponent), ""); err != types.ErrHoldNotActive {
+		t.Fatalf("got error %v, want %v", err, types.ErrHoldNotActive)
+	}
+
+	got, err := wr.Mutate(ctx, withdrawal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Held.IsZero() || got.Balance.Cmp(types.NewMoney(5000, types.DefaultCurrencyExponent)) != 0 {
+		t.Fatalf("got balance %s held %s, want 50.00 held 0", got.Balance, got.Held)
+	}
+}
+
+func testCreateHoldRejectsDuplicateReferenceID(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(10000, types.DefaultCurrencyExponent))
+
+	hold := types.Hold{
+		ID:             uuid.NewString(),
+		WalletID:       wallet.ID,
+		ReferenceID:    uuid.NewString(),
+		Status:         int(types.HoldStatusActive),
+		Amount:         types.NewMoney(1000, types.DefaultCurrencyExponent),
+		CapturedAmount: types.NewMoney(0, types.DefaultCurrencyExponent),
+		CreatedAt:      time.Now(),
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}
+	if err := wr.CreateHold(ctx, hold); err != nil {
+		t.Fatal(err)
+	}
+
+	hold.ID = uuid.NewString()
+	if err := wr.CreateHold(ctx, hold); !errors.Is(err, types.ErrDuplicateReference) {
+		t.Fatalf("hold with a duplicate reference_id: got %v, want %v", err, types.ErrDuplicateReference)
+	}
+
+	got, err := wr.GetByID(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := types.NewMoney(1000, types.DefaultCurrencyExponent); got.Held.Cmp(want) != 0 {
+		t.Fatalf("got held %s, want %s", got.Held, want)
+	}
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+type ledgerRepository struct {
+	db dbtx
+}
+
+const (
+	createLedgerAccountQuery = `
+		INSERT INTO ledger_accounts (id, kind, name)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING;
+	`
+
+	createJournalEntryQuery = `
+		INSERT INTO journal_entries (id, mutation_id, created_at, description)
+		VALUES ($1, $2, $3, $4);
+	`
+
+	createPostingQuery = `
+		INSERT INTO postings (entry_id, account_id, amount, currency)
+		VALUES ($1, $2, $3, $4);
+	`
+
+	// Accounts without postings are listed once, in the default currency.
+	// The cast keeps the sum an integer on Postgres, where summing bigints
+	// gives a numeric.
+	listAccountBalancesQuery = `
+		SELECT a.id, a.kind, a.name, CAST(COALESCE(SUM(p.amount), 0) AS bigint), COALESCE(p.currency, $1)
+		FROM ledger_accounts a
+		LEFT JOIN postings p ON p.account_id = a.id
+		GROUP BY a.id, a.kind, a.name, p.currency
+		ORDER BY a.kind, a.id, p.currency;
+	`
+)
+
+// NewLedgerRepository returns a ledger repository outside of any wallet
+// transaction. Writes that must commit together with wallet changes go
+// through WalletRepository.Ledger inside WithinTransaction instead.
+func NewLedgerRepository(db *sql.DB) types.LedgerRepository {
+	return &ledgerRepository{
+		db: db,
+	}
+}
+
+// CreateAccount is a no-op for an account that already exists.
+func (lr *ledgerRepository) CreateAccount(ctx context.Context, req types.LedgerAccount) error {
+	_, err := lr.db.ExecContext(ctx,
+		createLedgerAccountQuery,
+		req.ID,
+		req.Kind,
+		req.Name,
+	)
+
+	return err
+}
+
+// PostJournalEntry must run inside a transaction for the entry and its
+// postings to be stored atomically.
+func (lr *ledgerRepository) PostJournalEntry(ctx context.Context, req types.JournalEntry) error {
+	if !req.IsBalanced() {
+		return types.ErrUnbalancedEntry
+	}
+
+	_, err := lr.db.ExecContext(ctx,
+		createJournalEntryQuery,
+		req.ID,
+		req.MutationID,
+		req.CreatedAt.UTC(),
+		req.Description,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, posting := range req.Postings {
+		_, err = lr.db.ExecContext(ctx,
+			createPostingQuery,
+			req.ID,
+			posting.AccountID,
+			posting.Amount,
+			posting.Currency,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (lr *ledgerRepository) ListAccountBalances(ctx context.Context) ([]types.AccountBalance, error) {
+	rows, err := lr.db.QueryContext(ctx, listAccountBalancesQuery, types.DefaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []types.AccountBalance
+	for rows.Next() {
+		var balance types.AccountBalance
+		err := rows.Scan(
+			&balance.AccountID,
+			&balance.Kind,
+			&balance.Name,
+			&balance.Balance,
+			&balance.Currency,
+		)
+		if err != nil {
+			return nil, err
+		}
+		balance.Balance.Exponent = types.CurrencyExponent(balance.Currency)
+
+		balances = append(balances, balance)
+	}
+
+	return balances, rows.Err()
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func testMutatePostsBalancedJournalEntries(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	mutations := []types.Mutation{
+		newMutation(wallet, types.MutationActionDeposit, types.NewMoney(5000, types.DefaultCurrencyExponent)),
+		newMutation(wallet, types.MutationActionWithdraw, types.NewMoney(1250, types.DefaultCurrencyExponent)),
+	}
+	for _, m := range mutations {
+		if _, err := wr.Mutate(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	balances, err := wr.Ledger().ListAccountBalances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := types.NewMoney(0, types.DefaultCurrencyExponent)
+	got := map[string]types.Money{}
+	for _, b := range balances {
+		if total, err = total.Add(b.Balance); err != nil {
+			t.Fatal(err)
+		}
+		got[b.AccountID] = b.Balance
+	}
+	if !total.IsZero() {
+		t.Fatalf("got trial balance total %s, want 0", total)
+	}
+
+	want := map[string]types.Money{
+		types.WalletLedgerAccountID(wallet.ID): types.NewMoney(3750, types.DefaultCurrencyExponent),
+		types.LedgerAccountCashIn:              types.NewMoney(-5000, types.DefaultCurrencyExponent),
+		types.LedgerAccountCashOut:             types.NewMoney(1250, types.DefaultCurrencyExponent),
+	}
+	for account, balance := range want {
+		if got[account].Cmp(balance) != 0 {
+			t.Errorf("got %s balance %s, want %s", account, got[account], balance)
+		}
+	}
+}
+
+func testPostJournalEntryRejectsUnbalancedEntry(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+
+	err := wr.Ledger().PostJournalEntry(ctx, types.JournalEntry{
+		ID:        "entry",
+		CreatedAt: time.Now(),
+		Postings: []types.Posting{
+			{AccountID: types.LedgerAccountCashIn, Amount: types.NewMoney(-100, types.DefaultCurrencyExponent)},
+			{AccountID: types.LedgerAccountFees, Amount: types.NewMoney(99, types.DefaultCurrencyExponent)},
+		},
+	})
+	if err != types.ErrUnbalancedEntry {
+		t.Fatalf("got error %v, want %v", err, types.ErrUnbalancedEntry)
+	}
+}
@@ -0,0 +1,505 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+// errDuplicateKey is the in-memory counterpart of a unique constraint
+// violation.
+var errDuplicateKey = errors.New("duplicate key")
+
+// memoryData is the content of an in-memory database. Rows are kept by
+// value, so nothing handed to or returned by the repository is shared with
+// it.
+type memoryData struct {
+	wallets        map[string]types.Wallet
+	mutations      map[string]types.Mutation
+	mutationRefs   map[string]string
+	holds          map[string]types.Hold
+	holdRefs       map[string]string
+	tokens         map[string]types.Token
+	ledgerAccounts map[string]types.LedgerAccount
+	journalEntries map[string]types.JournalEntry
+	postings       []types.Posting
+	// fxRates are in insertion order, which breaks ties between rates
+	// with the same effective time like the id column does.
+	fxRates  []types.FXRate
+	fxQuotes map[string]types.FXQuote
+}
+
+type memoryDB struct {
+	mu   sync.RWMutex
+	data *memoryData
+}
+
+type memoryWalletRepository struct {
+	db *memoryDB
+	// tx is the copy of the data a transaction works on, and nil outside
+	// of one.
+	tx *memoryData
+}
+
+// NewMemoryWalletRepository returns a wallet repository that keeps
+// everything in memory, for tests and demos. It has the unique keys and
+// transactional behaviour of the SQLite repository, including letting one
+// transaction write at a time. Every transaction works on a copy of the
+// data, which it replaces on commit.
+func NewMemoryWalletRepository() types.WalletRepository {
+	data := newMemoryData()
+	for _, account := range types.SystemLedgerAccounts {
+		data.createAccount(account)
+	}
+
+	return &memoryWalletRepository{
+		db: &memoryDB{data: data},
+	}
+}
+
+func (mr *memoryWalletRepository) WithinTransaction(ctx context.Context, fn func(types.WalletRepository) error) error {
+	return mr.withinTransaction(ctx, func(tr *memoryWalletRepository) error {
+		return fn(tr)
+	})
+}
+
+func (mr *memoryWalletRepository) Ledger() types.LedgerRepository {
+	return &memoryLedgerRepository{
+		mr: mr,
+	}
+}
+
+func (mr *memoryWalletRepository) FX() types.FXRepository {
+	return &memoryFXRepository{
+		mr: mr,
+	}
+}
+
+func (mr *memoryWalletRepository) Create(ctx context.Context, req types.Wallet) error {
+	return mr.write(ctx, func(d *memoryData) error {
+		if _, ok := d.wallets[req.ID]; ok {
+			return duplicateKey("wallet", req.ID)
+		}
+
+		req.Balance = inCurrency(req.Balance, req.Currency)
+		req.Held = inCurrency(types.Money{}, req.Currency)
+		d.wallets[req.ID] = req
+		d.createAccount(types.NewWalletLedgerAccount(req))
+		return nil
+	})
+}
+
+func (mr *memoryWalletRepository) Enable(ctx context.Context, id string) (types.Wallet, error) {
+	return mr.setStatus(ctx, id, types.StatusActive)
+}
+
+func (mr *memoryWalletRepository) GetByToken(ctx context.Context, token string) (types.Wallet, error) {
+	var data types.Wallet
+	err := mr.read(ctx, func(d *memoryData) error {
+		t, err := d.getToken(token, time.Now())
+		if err == types.ErrTokenNotFound {
+			return sql.ErrNoRows
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err = d.getWallet(t.WalletID)
+		return err
+	})
+
+	return data, err
+}
+
+func (mr *memoryWalletRepository) GetByID(ctx context.Context, id string) (types.Wallet, error) {
+	var data types.Wallet
+	err := mr.read(ctx, func(d *memoryData) error {
+		var err error
+		data, err = d.getWallet(id)
+		return err
+	})
+
+	return data, err
+}
+
+func (mr *memoryWalletRepository) ListByOwnedBy(ctx context.Context, ownedBy string) ([]types.Wallet, error) {
+	var wallets []types.Wallet
+	err := mr.read(ctx, func(d *memoryData) error {
+		for _, wallet := range d.wallets {
+			if wallet.OwnedBy == ownedBy {
+				wallets = append(wallets, wallet)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(wallets, func(i, j int) bool { return wallets[i].ID < wallets[j].ID })
+	return wallets, err
+}
+
+func (mr *memoryWalletRepository) Disable(ctx context.Context, id string) (types.Wallet, error) {
+	return mr.setStatus(ctx, id, types.StatusInactive)
+}
+
+// AddWalletBalanceByID applies the same conditions as the SQL update, on
+// minor units.
+func (mr *memoryWalletRepository) AddWalletBalanceByID(ctx context.Context, delta types.Money, id string) (types.Wallet, error) {
+	var data types.Wallet
+	err := mr.write(ctx, func(d *memoryData) error {
+		wallet, err := d.getWallet(id)
+		if err != nil {
+			return err
+		}
+		if wallet.Status != int(types.StatusActive) {
+			return types.ErrWalletInactive
+		}
+		balance, err := wallet.Balance.Add(delta)
+		if err != nil {
+			return types.ErrBalanceOverflow
+		}
+		if balance.Minor < wallet.Held.Minor {
+			return types.ErrInsufficientFunds
+		}
+
+		wallet.Balance = balance
+		wallet.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		d.wallets[id] = wallet
+		data = wallet
+		return nil
+	})
+	if err != nil {
+		return types.Wallet{}, err
+	}
+
+	return data, nil
+}
+
+// LockWallets has nothing to do: a transaction holds the write lock of the
+// whole database.
+func (mr *memoryWalletRepository) LockWallets(ctx context.Context, ids ...string) error {
+	return nil
+}
+
+func (mr *memoryWalletRepository) CreateMutation(ctx context.Context, req types.Mutation) error {
+	return mr.write(ctx, func(d *memoryData) error {
+		if _, ok := d.mutations[req.ID]; ok {
+			return duplicateKey("mutation", req.ID)
+		}
+		if _, ok := d.mutationRefs[req.ReferenceID]; ok {
+			return duplicateReference(req.ReferenceID)
+		}
+
+		req.Amount = inCurrency(req.Amount, req.Currency)
+		req.ReversedAmount = inCurrency(types.Money{}, req.Currency)
+		d.mutations[req.ID] = req
+		d.mutationRefs[req.ReferenceID] = req.ID
+		return nil
+	})
+}
+
+func (mr *memoryWalletRepository) GetMutationByReferenceID(ctx context.Context, referenceID string) (types.Mutation, error) {
+	var data types.Mutation
+	err := mr.read(ctx, func(d *memoryData) error {
+		id, ok := d.mutationRefs[referenceID]
+		if !ok {
+			return sql.ErrNoRows
+		}
+
+		data = d.mutations[id]
+		return nil
+	})
+
+	return data, err
+}
+
+func (mr *memoryWalletRepository) GetMutationByID(ctx context.Context, id string) (types.Mutation, error) {
+	var data types.Mutation
+	err := mr.read(ctx, func(d *memoryData) error {
+		var ok bool
+		if data, ok = d.mutations[id]; !ok {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+
+	return data, err
+}
+
+func (mr *memoryWalletRepository) AddReversedAmount(ctx context.Context, id string, amount types.Money) error {
+	return mr.write(ctx, func(d *memoryData) error {
+		mutation, ok := d.mutations[id]
+		if !ok || mutation.Status != int(types.MutationStatusSuccess) || mutation.ReversedAmount.Minor+amount.Minor > mutation.Amount.Minor {
+			return types.ErrReversalExceedsAmount
+		}
+
+		mutation.ReversedAmount.Minor += amount.Minor
+		d.mutations[id] = mutation
+		return nil
+	})
+}
+
+// Mutate records the mutation, applies its amount to the wallet and posts
+// the matching journal entry in a single transaction.
+func (mr *memoryWalletRepository) Mutate(ctx context.Context, req types.Mutation) (types.Wallet, error) {
+	var data types.Wallet
+	err := mr.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		var err error
+		data, err = repo.AddWalletBalanceByID(ctx, req.BalanceDelta(), req.WalletID)
+		if err != nil {
+			return err
+		}
+
+		if err = repo.CreateMutation(ctx, req); err != nil {
+			return err
+		}
+
+		return repo.Ledger().PostJournalEntry(ctx, types.NewMutationJournalEntry(req, data.ID))
+	})
+	if err != nil {
+		return types.Wallet{}, err
+	}
+
+	return data, nil
+}
+
+// ListMutation pages with the same keyset on (created_at, id) as
+// buildMutationListQuery.
+func (mr *memoryWalletRepository) ListMutation(ctx context.Context, filter types.MutationFilter) ([]types.Mutation, error) {
+	var mutations []types.Mutation
+	err := mr.read(ctx, func(d *memoryData) error {
+		var after types.Mutation
+		if filter.AfterID != "" {
+			var ok bool
+			if after, ok = d.mutations[filter.AfterID]; !ok {
+				return nil
+			}
+		}
+
+		for _, mutation := range d.mutations {
+			if !matchesMutationFilter(mutation, filter) {
+				continue
+			}
+			if filter.AfterID != "" {
+				pastCursor := mutationBefore(mutation, after)
+				if filter.Ascending {
+					pastCursor = mutationBefore(after, mutation)
+				}
+				if !pastCursor {
+					continue
+				}
+			}
+
+			mutations = append(mutations, mutation)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(mutations, func(i, j int) bool {
+		return mutationBefore(mutations[i], mutations[j]) == filter.Ascending
+	})
+	if filter.Limit >= 0 && len(mutations) > filter.Limit {
+		mutations = mutations[:filter.Limit]
+	}
+
+	return mutations, nil
+}
+
+// helpers
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		wallets:        map[string]types.Wallet{},
+		mutations:      map[string]types.Mutation{},
+		mutationRefs:   map[string]string{},
+		holds:          map[string]types.Hold{},
+		holdRefs:       map[string]string{},
+		tokens:         map[string]types.Token{},
+		ledgerAccounts: map[string]types.LedgerAccount{},
+		journalEntries: map[string]types.JournalEntry{},
+		fxQuotes:       map[string]types.FXQuote{},
+	}
+}
+
+// clone copies the maps and slices of the data. The rows themselves are
+// values and are never changed in place.
+func (d *memoryData) clone() *memoryData {
+	c := newMemoryData()
+	for k, v := range d.wallets {
+		c.wallets[k] = v
+	}
+	for k, v := range d.mutations {
+		c.mutations[k] = v
+	}
+	for k, v := range d.mutationRefs {
+		c.mutationRefs[k] = v
+	}
+	for k, v := range d.holds {
+		c.holds[k] = v
+	}
+	for k, v := range d.holdRefs {
+		c.holdRefs[k] = v
+	}
+	for k, v := range d.tokens {
+		c.tokens[k] = v
+	}
+	for k, v := range d.ledgerAccounts {
+		c.ledgerAccounts[k] = v
+	}
+	for k, v := range d.journalEntries {
+		c.journalEntries[k] = v
+	}
+	for k, v := range d.fxQuotes {
+		c.fxQuotes[k] = v
+	}
+	c.postings = append(c.postings, d.postings...)
+	c.fxRates = append(c.fxRates, d.fxRates...)
+
+	return c
+}
+
+// withinTransaction runs fn on a copy of the data, holding the write lock,
+// and keeps the copy if fn returns nil. Calling it on a repository that is
+// already transactional joins the existing transaction.
+func (mr *memoryWalletRepository) withinTransaction(ctx context.Context, fn func(*memoryWalletRepository) error) error {
+	if mr.tx != nil {
+		return fn(mr)
+	}
+
+	mr.db.mu.Lock()
+	defer mr.db.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tx := mr.db.data.clone()
+	err := fn(&memoryWalletRepository{
+		db: mr.db,
+		tx: tx,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Like a database transaction, one whose context ended does not
+	// commit.
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	mr.db.data = tx
+	return nil
+}
+
+// write runs fn on the data of a transaction, see withinTransaction.
+func (mr *memoryWalletRepository) write(ctx context.Context, fn func(*memoryData) error) error {
+	return mr.withinTransaction(ctx, func(tr *memoryWalletRepository) error {
+		return fn(tr.tx)
+	})
+}
+
+// read runs fn on the data of the current transaction or, outside of one,
+// on the committed data under the read lock. fn must not change the data.
+func (mr *memoryWalletRepository) read(ctx context.Context, fn func(*memoryData) error) error {
+	if mr.tx != nil {
+		return fn(mr.tx)
+	}
+
+	mr.db.mu.RLock()
+	defer mr.db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fn(mr.db.data)
+}
+
+func (mr *memoryWalletRepository) setStatus(ctx context.Context, id string, status types.WalletStatus) (types.Wallet, error) {
+	var data types.Wallet
+	err := mr.write(ctx, func(d *memoryData) error {
+		wallet, err := d.getWallet(id)
+		if err != nil {
+			return err
+		}
+
+		wallet.Status = int(status)
+		wallet.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		d.wallets[id] = wallet
+		data = wallet
+		return nil
+	})
+
+	return data, err
+}
+
+func (d *memoryData) getWallet(id string) (types.Wallet, error) {
+	wallet, ok := d.wallets[id]
+	if !ok {
+		return types.Wallet{}, sql.ErrNoRows
+	}
+
+	return wallet, nil
+}
+
+func matchesMutationFilter(m types.Mutation, filter types.MutationFilter) bool {
+	if m.WalletID != filter.WalletID {
+		return false
+	}
+	if len(filter.Actions) > 0 {
+		found := false
+		for _, action := range filter.Actions {
+			found = found || int(action) == m.Action
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.Status != 0 && int(filter.Status) != m.Status {
+		return false
+	}
+	if filter.MinAmount != nil && m.Amount.Minor < filter.MinAmount.Minor {
+		return false
+	}
+	if filter.MaxAmount != nil && m.Amount.Minor > filter.MaxAmount.Minor {
+		return false
+	}
+	if !filter.From.IsZero() && m.CreatedAt.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !m.CreatedAt.Before(filter.To) {
+		return false
+	}
+	if filter.ReferenceID != "" && m.ReferenceID != filter.ReferenceID {
+		return false
+	}
+
+	return true
+}
+
+// mutationBefore orders mutations by creation time and then ID.
+func mutationBefore(a, b types.Mutation) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// inCurrency reads back an amount the way the SQL repositories do: as its
+// minor units at the exponent of the currency.
+func inCurrency(m types.Money, currency string) types.Money {
+	return types.NewMoney(m.Minor, types.CurrencyExponent(currency))
+}
+
+func duplicateKey(key, value string) error {
+	return fmt.Errorf("%w: %s %q", errDuplicateKey, key, value)
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+type memoryFXRepository struct {
+	mr *memoryWalletRepository
+}
+
+func (fr *memoryFXRepository) CreateRate(ctx context.Context, req types.FXRate) error {
+	return fr.mr.write(ctx, func(d *memoryData) error {
+		d.fxRates = append(d.fxRates, req)
+		return nil
+	})
+}
+
+// GetRate prefers the rate loaded last among those with the same effective
+// time, like getFXRateQuery.
+func (fr *memoryFXRepository) GetRate(ctx context.Context, base, quote string, at time.Time) (types.FXRate, error) {
+	var (
+		data  types.FXRate
+		found bool
+	)
+	err := fr.mr.read(ctx, func(d *memoryData) error {
+		for _, rate := range d.fxRates {
+			if rate.Base != base || rate.Quote != quote || rate.EffectiveAt.After(at) {
+				continue
+			}
+			if !found || !rate.EffectiveAt.Before(data.EffectiveAt) {
+				data, found = rate, true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return types.FXRate{}, err
+	}
+	if !found {
+		return types.FXRate{}, types.ErrRateNotFound
+	}
+
+	return data, nil
+}
+
+func (fr *memoryFXRepository) CreateQuote(ctx context.Context, req types.FXQuote) error {
+	return fr.mr.write(ctx, func(d *memoryData) error {
+		if _, ok := d.fxQuotes[req.ID]; ok {
+			return duplicateKey("fx quote", req.ID)
+		}
+
+		req.Amount = inCurrency(req.Amount, req.FromCurrency)
+		req.ConvertedAmount = inCurrency(req.ConvertedAmount, req.ToCurrency)
+		d.fxQuotes[req.ID] = req
+		return nil
+	})
+}
+
+func (fr *memoryFXRepository) GetQuote(ctx context.Context, id string) (types.FXQuote, error) {
+	var data types.FXQuote
+	err := fr.mr.read(ctx, func(d *memoryData) error {
+		var ok bool
+		if data, ok = d.fxQuotes[id]; !ok {
+			return types.ErrQuoteNotFound
+		}
+		return nil
+	})
+
+	return data, err
+}
+
+func (fr *memoryFXRepository) UseQuote(ctx context.Context, id string, at time.Time) error {
+	return fr.mr.write(ctx, func(d *memoryData) error {
+		quote, ok := d.fxQuotes[id]
+		if !ok || quote.UsedAt.Valid {
+			return types.ErrQuoteUsed
+		}
+
+		quote.UsedAt = sql.NullTime{Time: at, Valid: true}
+		d.fxQuotes[id] = quote
+		return nil
+	})
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func (mr *memoryWalletRepository) CreateHold(ctx context.Context, req types.Hold) error {
+	return mr.write(ctx, func(d *memoryData) error {
+		wallet, err := d.getWallet(req.WalletID)
+		if err != nil {
+			return err
+		}
+		if wallet.Status != int(types.StatusActive) {
+			return types.ErrWalletInactive
+		}
+		if wallet.Balance.Minor-wallet.Held.Minor-req.Amount.Minor < 0 {
+			return types.ErrInsufficientFunds
+		}
+
+		if _, ok := d.holds[req.ID]; ok {
+			return duplicateKey("hold", req.ID)
+		}
+		if _, ok := d.holdRefs[req.ReferenceID]; ok {
+			return duplicateReference(req.ReferenceID)
+		}
+
+		wallet.Held.Minor += req.Amount.Minor
+		wallet.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		d.wallets[wallet.ID] = wallet
+
+		req.Amount = inCurrency(req.Amount, req.Currency)
+		req.CapturedAmount = inCurrency(req.CapturedAmount, req.Currency)
+		d.holds[req.ID] = req
+		d.holdRefs[req.ReferenceID] = req.ID
+		return nil
+	})
+}
+
+func (mr *memoryWalletRepository) GetHold(ctx context.Context, id string) (types.Hold, error) {
+	var data types.Hold
+	err := mr.read(ctx, func(d *memoryData) error {
+		var ok bool
+		if data, ok = d.holds[id]; !ok {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+
+	return data, err
+}
+
+func (mr *memoryWalletRepository) GetHoldByReferenceID(ctx context.Context, referenceID string) (types.Hold, error) {
+	var data types.Hold
+	err := mr.read(ctx, func(d *memoryData) error {
+		id, ok := d.holdRefs[referenceID]
+		if !ok {
+			return sql.ErrNoRows
+		}
+
+		data = d.holds[id]
+		return nil
+	})
+
+	return data, err
+}
+
+func (mr *memoryWalletRepository) ListActiveHolds(ctx context.Context, walletID string) ([]types.Hold, error) {
+	var holds []types.Hold
+	err := mr.read(ctx, func(d *memoryData) error {
+		for _, hold := range d.holds {
+			if hold.WalletID == walletID && hold.Status == int(types.HoldStatusActive) {
+				holds = append(holds, hold)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(holds, func(i, j int) bool {
+		if !holds[i].ExpiresAt.Equal(holds[j].ExpiresAt) {
+			return holds[i].ExpiresAt.Before(holds[j].ExpiresAt)
+		}
+		return holds[i].ID < holds[j].ID
+	})
+	return holds, err
+}
+
+func (mr *memoryWalletRepository) ReleaseHold(ctx context.Context, id string, status types.HoldStatus, captured types.Money, mutationID string) (types.Hold, error) {
+	var data types.Hold
+	err := mr.write(ctx, func(d *memoryData) error {
+		hold, ok := d.holds[id]
+		if !ok || hold.Status != int(types.HoldStatusActive) {
+			return types.ErrHoldNotActive
+		}
+
+		now := time.Now()
+		hold.Status = int(status)
+		hold.CapturedAmount = inCurrency(captured, hold.Currency)
+		hold.MutationID = mutationID
+		hold.UpdatedAt = sql.NullTime{Time: now, Valid: true}
+		d.holds[id] = hold
+		data = hold
+
+		if wallet, ok := d.wallets[hold.WalletID]; ok {
+			wallet.Held.Minor -= hold.Amount.Minor
+			wallet.UpdatedAt = sql.NullTime{Time: now, Valid: true}
+			d.wallets[wallet.ID] = wallet
+		}
+		return nil
+	})
+	if err != nil {
+		return types.Hold{}, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"sort"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+type memoryLedgerRepository struct {
+	mr *memoryWalletRepository
+}
+
+// CreateAccount is a no-op for an account that already exists.
+func (lr *memoryLedgerRepository) CreateAccount(ctx context.Context, req types.LedgerAccount) error {
+	return lr.mr.write(ctx, func(d *memoryData) error {
+		d.createAccount(req)
+		return nil
+	})
+}
+
+func (lr *memoryLedgerRepository) PostJournalEntry(ctx context.Context, req types.JournalEntry) error {
+	if !req.IsBalanced() {
+		return types.ErrUnbalancedEntry
+	}
+
+	return lr.mr.write(ctx, func(d *memoryData) error {
+		if _, ok := d.journalEntries[req.ID]; ok {
+			return duplicateKey("journal entry", req.ID)
+		}
+
+		for _, posting := range req.Postings {
+			posting.EntryID = req.ID
+			d.postings = append(d.postings, posting)
+		}
+		req.Postings = nil
+		d.journalEntries[req.ID] = req
+		return nil
+	})
+}
+
+// ListAccountBalances lists accounts without postings once, in the default
+// currency, like listAccountBalancesQuery.
+func (lr *memoryLedgerRepository) ListAccountBalances(ctx context.Context) ([]types.AccountBalance, error) {
+	var balances []types.AccountBalance
+	err := lr.mr.read(ctx, func(d *memoryData) error {
+		sums := map[string]map[string]int64{}
+		for _, posting := range d.postings {
+			if sums[posting.AccountID] == nil {
+				sums[posting.AccountID] = map[string]int64{}
+			}
+			sums[posting.AccountID][posting.Currency] += posting.Amount.Minor
+		}
+
+		for _, account := range d.ledgerAccounts {
+			if len(sums[account.ID]) == 0 {
+				sums[account.ID] = map[string]int64{types.DefaultCurrency: 0}
+			}
+
+			for currency, sum := range sums[account.ID] {
+				balances = append(balances, types.AccountBalance{
+					AccountID: account.ID,
+					Kind:      account.Kind,
+					Name:      account.Name,
+					Balance:   types.NewMoney(sum, types.CurrencyExponent(currency)),
+					Currency:  currency,
+				})
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(balances, func(i, j int) bool {
+		a, b := balances[i], balances[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		return a.Currency < b.Currency
+	})
+	return balances, err
+}
+
+// helpers
+
+func (d *memoryData) createAccount(account types.LedgerAccount) {
+	if _, ok := d.ledgerAccounts[account.ID]; !ok {
+		d.ledgerAccounts[account.ID] = account
+	}
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func (mr *memoryWalletRepository) CreateToken(ctx context.Context, req types.Token) error {
+	return mr.write(ctx, func(d *memoryData) error {
+		if _, ok := d.tokens[req.ID]; ok {
+			return duplicateKey("token", req.ID)
+		}
+		for _, token := range d.tokens {
+			if token.Hash == req.Hash {
+				return duplicateKey("token hash", req.Hash)
+			}
+		}
+
+		req.CreatedAt = req.CreatedAt.UTC()
+		d.tokens[req.ID] = req
+		return nil
+	})
+}
+
+func (mr *memoryWalletRepository) GetToken(ctx context.Context, token string) (types.Token, error) {
+	var data types.Token
+	err := mr.read(ctx, func(d *memoryData) error {
+		var err error
+		data, err = d.getToken(token, time.Now())
+		return err
+	})
+
+	return data, err
+}
+
+func (mr *memoryWalletRepository) ListTokens(ctx context.Context, walletID string) ([]types.Token, error) {
+	var tokens []types.Token
+	err := mr.read(ctx, func(d *memoryData) error {
+		for _, token := range d.tokens {
+			if token.WalletID == walletID {
+				tokens = append(tokens, token)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, err
+}
+
+func (mr *memoryWalletRepository) ExpireToken(ctx context.Context, id string, at time.Time) error {
+	return mr.write(ctx, func(d *memoryData) error {
+		token, ok := d.tokens[id]
+		if !ok || (token.ExpiresAt.Valid && !token.ExpiresAt.Time.After(at)) {
+			return nil
+		}
+
+		token.ExpiresAt = sql.NullTime{Time: at.UTC(), Valid: true}
+		d.tokens[id] = token
+		return nil
+	})
+}
+
+func (mr *memoryWalletRepository) RevokeToken(ctx context.Context, id string, at time.Time) error {
+	return mr.write(ctx, func(d *memoryData) error {
+		token, ok := d.tokens[id]
+		if !ok || token.RevokedAt.Valid {
+			return nil
+		}
+
+		token.RevokedAt = sql.NullTime{Time: at.UTC(), Valid: true}
+		d.tokens[id] = token
+		return nil
+	})
+}
+
+// helpers
+
+// getToken finds a valid token by prefix and hash, like GetToken of the SQL
+// repositories.
+func (d *memoryData) getToken(token string, now time.Time) (types.Token, error) {
+	prefix := types.TokenPrefix(token)
+	for _, data := range d.tokens {
+		if data.Prefix != prefix || data.RevokedAt.Valid || (data.ExpiresAt.Valid && !data.ExpiresAt.Time.After(now)) {
+			continue
+		}
+
+		if data.Matches(token) {
+			return data, nil
+		}
+	}
+
+	return types.Token{}, types.ErrTokenNotFound
+}
@@ -0,0 +1,418 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+// Migrations are numbered SQL files in migrations/<driver>, named
+// NNNN_name.up.sql and NNNN_name.down.sql. A released migration is never
+// edited; a schema change is a new pair of files with the next number.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var (
+	sqliteMigrations   = mustLoadMigrations("migrations/sqlite")
+	postgresMigrations = mustLoadMigrations("migrations/postgres")
+
+	migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+// ErrSchemaTooNew is returned when the database has migrations applied
+// that this binary does not know of, because a newer version migrated it.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+const (
+	createSQLiteSchemaMigrationsStmt = `
+		CREATE TABLE schema_migrations (
+			version integer primary key,
+			name string not null,
+			applied_at timestamp not null
+		);
+	`
+
+	createPostgresSchemaMigrationsStmt = `
+		CREATE TABLE schema_migrations (
+			version bigint primary key,
+			name text not null,
+			applied_at timestamptz not null
+		);
+	`
+
+	sqliteTableExistsQuery = `
+		SELECT count(*)
+		FROM sqlite_master
+		WHERE type = 'table' AND name = $1;
+	`
+
+	postgresTableExistsQuery = `
+		SELECT count(*)
+		FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = $1;
+	`
+
+	listSchemaMigrationsQuery = `
+		SELECT version, name, applied_at
+		FROM schema_migrations
+		ORDER BY version;
+	`
+
+	insertSchemaMigrationQuery = `
+		INSERT INTO schema_migrations (version, name, applied_at)
+		VALUES ($1, $2, $3);
+	`
+
+	deleteSchemaMigrationQuery = `
+		DELETE FROM schema_migrations
+		WHERE version = $1;
+	`
+)
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version int
+	Name    string
+
+	up   string
+	down string
+}
+
+// MigrationStatus tells whether a migration is applied. Migrations applied
+// by a newer binary are listed with an empty Name.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the migrations embedded in the binary for one driver
+// and records them in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+
+	createTableStmt  string
+	tableExistsQuery string
+	// upgradeUnversioned brings a database from before migrations were
+	// versioned to the schema of the first migration.
+	upgradeUnversioned func(tx *sql.Tx) error
+}
+
+// NewSQLiteMigrator returns the migrator of the SQLite wallet repository.
+func NewSQLiteMigrator(db *sql.DB) *Migrator {
+	return &Migrator{
+		db:                 db,
+		migrations:         sqliteMigrations,
+		createTableStmt:    createSQLiteSchemaMigrationsStmt,
+		tableExistsQuery:   sqliteTableExistsQuery,
+		upgradeUnversioned: upgradeUnversioned,
+	}
+}
+
+// NewPostgresMigrator returns the migrator of the Postgres wallet
+// repository. Postgres databases from before migrations were versioned
+// already have the schema of the first migration.
+func NewPostgresMigrator(db *sql.DB) *Migrator {
+	return &Migrator{
+		db:                 db,
+		migrations:         postgresMigrations,
+		createTableStmt:    createPostgresSchemaMigrationsStmt,
+		tableExistsQuery:   postgresTableExistsQuery,
+		upgradeUnversioned: func(*sql.Tx) error { return nil },
+	}
+}
+
+// Migrate brings a SQLite database up to the latest schema.
+func Migrate(db *sql.DB) error {
+	_, err := NewSQLiteMigrator(db).Up(context.Background())
+	return err
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns those it applied. It makes sure the system ledger accounts exist
+// afterwards. A database migrated by a newer binary is left untouched.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.checkVersion(applied); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = m.withinTransaction(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, insertSchemaMigrationQuery, migration.Version, migration.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %s: %w", migration, err)
+		}
+		done = append(done, migration)
+	}
+
+	return done, m.withinTransaction(ctx, func(tx *sql.Tx) error {
+		for _, account := range types.SystemLedgerAccounts {
+			if _, err := tx.ExecContext(ctx, createLedgerAccountQuery, account.ID, account.Kind, account.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the most recently applied migration and returns it. It
+// returns false if no migration is applied.
+func (m *Migrator) Down(ctx context.Context) (Migration, bool, error) {
+	applied, err := m.prepare(ctx)
+	if err != nil {
+		return Migration{}, false, err
+	}
+
+	if err = m.checkVersion(applied); err != nil {
+		return Migration{}, false, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err = m.withinTransaction(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migration.down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, deleteSchemaMigrationQuery, migration.Version)
+			return err
+		})
+		if err != nil {
+			return Migration{}, false, fmt.Errorf("migration %s: %w", migration, err)
+		}
+		return migration, true, nil
+	}
+
+	return Migration{}, false, nil
+}
+
+// Status lists the migrations of the binary and those applied to the
+// database, by version, without changing the database.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var applied map[int]MigrationStatus
+	err := m.withinTransaction(ctx, func(tx *sql.Tx) error {
+		exists, err := m.tableExists(ctx, tx, "schema_migrations")
+		if err != nil || !exists {
+			return err
+		}
+
+		applied, err = m.listApplied(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status, ok := applied[migration.Version]
+		if !ok {
+			status = MigrationStatus{Version: migration.Version}
+		}
+		status.Name = migration.Name
+		statuses = append(statuses, status)
+		delete(applied, migration.Version)
+	}
+
+	for _, status := range applied {
+		status.Name = ""
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// helpers
+
+// prepare creates the schema_migrations table if needed and returns the
+// applied migrations. A database that has tables but no schema_migrations
+// table is from before migrations were versioned: it is upgraded to and
+// recorded at the first migration.
+func (m *Migrator) prepare(ctx context.Context) (map[int]MigrationStatus, error) {
+	var applied map[int]MigrationStatus
+	err := m.withinTransaction(ctx, func(tx *sql.Tx) error {
+		exists, err := m.tableExists(ctx, tx, "schema_migrations")
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			if err = m.adoptUnversioned(ctx, tx); err != nil {
+				return err
+			}
+		}
+
+		applied, err = m.listApplied(ctx, tx)
+		return err
+	})
+
+	return applied, err
+}
+
+func (m *Migrator) adoptUnversioned(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, m.createTableStmt); err != nil {
+		return err
+	}
+
+	unversioned, err := m.tableExists(ctx, tx, "wallets")
+	if err != nil || !unversioned {
+		return err
+	}
+
+	if err = m.upgradeUnversioned(tx); err != nil {
+		return err
+	}
+
+	baseline := m.migrations[0]
+	_, err = tx.ExecContext(ctx, insertSchemaMigrationQuery, baseline.Version, baseline.Name, time.Now().UTC())
+	return err
+}
+
+// checkVersion refuses a database with migrations applied that the binary
+// does not know of.
+func (m *Migrator) checkVersion(applied map[int]MigrationStatus) error {
+	known := map[int]bool{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+	}
+
+	latest := m.migrations[len(m.migrations)-1].Version
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: version %d is applied, this binary knows up to %d", ErrSchemaTooNew, version, latest)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) listApplied(ctx context.Context, tx *sql.Tx) (map[int]MigrationStatus, error) {
+	rows, err := tx.QueryContext(ctx, listSchemaMigrationsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]MigrationStatus{}
+	for rows.Next() {
+		status := MigrationStatus{Applied: true}
+		if err = rows.Scan(&status.Version, &status.Name, &status.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[status.Version] = status
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) tableExists(ctx context.Context, tx *sql.Tx, table string) (bool, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, m.tableExistsQuery, table).Scan(&count)
+	return count > 0, err
+}
+
+func (m *Migrator) withinTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// mustLoadMigrations reads the migrations embedded in dir, ordered by
+// version. Every version must have an up and a down file.
+func mustLoadMigrations(dir string) []Migration {
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			panic(fmt.Sprintf("migration %s/%s: want NNNN_name.up.sql or NNNN_name.down.sql", dir, entry.Name()))
+		}
+
+		body, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			panic(fmt.Sprintf("migration %s/%s: version %d is also named %s", dir, entry.Name(), version, migration.Name))
+		}
+
+		if match[3] == "up" {
+			migration.up = string(body)
+		} else {
+			migration.down = string(body)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			panic(fmt.Sprintf("migration %s/%s: want both an up and a down file", dir, migration))
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	if len(migrations) == 0 {
+		panic("no migrations in " + dir)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations
+}
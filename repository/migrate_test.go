@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func TestMigratorRevertsAndReappliesMigrations(t *testing.T) {
+	ctx := context.Background()
+	m := NewSQLiteMigrator(openSQLiteTestDB(t))
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(sqliteMigrations) {
+		t.Fatalf("first Up applied %v, want all of %v", applied, sqliteMigrations)
+	}
+	if applied, err = m.Up(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("second Up = %v, %v, want nothing applied", applied, err)
+	}
+
+	latest := sqliteMigrations[len(sqliteMigrations)-1]
+	reverted, ok, err := m.Down(ctx)
+	if err != nil || !ok || reverted.Version != latest.Version {
+		t.Fatalf("Down = %v, %t, %v, want %v reverted", reverted, ok, err, latest)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := statuses[len(statuses)-1]; last.Version != latest.Version || last.Applied {
+		t.Fatalf("status after Down = %+v, want %v pending", last, latest)
+	}
+
+	if applied, err = m.Up(ctx); err != nil || len(applied) != 1 || applied[0].Version != latest.Version {
+		t.Fatalf("Up after Down = %v, %v, want %v applied", applied, err, latest)
+	}
+}
+
+func TestMigratorRefusesNewerSchema(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLiteTestDB(t)
+	m := NewSQLiteMigrator(db)
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(insertSchemaMigrationQuery, 9999, "from_the_future", "2030-01-01 00:00:00"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(ctx); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Up: got %v, want %v", err, ErrSchemaTooNew)
+	}
+	if _, _, err := m.Down(ctx); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Down: got %v, want %v", err, ErrSchemaTooNew)
+	}
+}
+
+// A database from before migrations were versioned, here one with
+// amounts in real columns and tokens in the wallets table, is upgraded in
+// place and recorded at the first migration.
+func TestMigratorAdoptsUnversionedDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLiteTestDB(t)
+
+	for _, stmt := range []string{
+		`CREATE TABLE wallets (
+			id string primary key,
+			owned_by string unique not null,
+			token string unique not null,
+			status int not null,
+			updated_at timestamp,
+			balance real not null
+		);`,
+		`INSERT INTO wallets VALUES ('w1', 'alice', 'legacy-token', 1, CURRENT_TIMESTAMP, 12.5);`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewSQLiteMigrator(db).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := NewSQLiteMigrator(db).Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("migration %04d_%s is pending after Up", status.Version, status.Name)
+		}
+	}
+
+	wallet, err := NewWalletRepositiory(db).GetByToken(ctx, "legacy-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := types.NewMoney(1250, types.DefaultCurrencyExponent); wallet.Balance.Cmp(want) != 0 {
+		t.Errorf("balance = %s, want %s", wallet.Balance, want)
+	}
+}
+
+// Timestamps written in local time before they were all stored in UTC are
+// rewritten in UTC, and new ones are written in UTC whatever their zone.
+func TestTimestampsAreStoredInUTC(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLiteTestDB(t)
+	m := NewSQLiteMigrator(db)
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if reverted, _, err := m.Down(ctx); err != nil || reverted.Name != "utc_timestamps" {
+		t.Fatalf("Down = %v, %v, want utc_timestamps reverted", reverted, err)
+	}
+
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	createdAt := time.Date(2024, 1, 1, 7, 30, 0, 123456789, jakarta)
+	for _, id := range []string{"m1", "m2"} {
+		if _, err := db.Exec(`INSERT INTO mutations (id, reference_id, created_at, created_by, action, status, amount) VALUES ($1, $1, $2, 'alice', 1, 1, 100)`, id, createdAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`UPDATE mutations SET created_at = '2024-01-01 07:30:00-05:00' WHERE id = 'm2'`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	wr := NewWalletRepositiory(db)
+	err := wr.CreateMutation(ctx, types.Mutation{ID: "m3", ReferenceID: "m3", CreatedAt: createdAt, CreatedBy: "alice", Action: 1, Status: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]string{
+		"m1": "2024-01-01 00:30:00.123456789+00:00",
+		"m2": "2024-01-01 12:30:00+00:00",
+		"m3": "2024-01-01 00:30:00.123456789+00:00",
+	} {
+		// Concatenating reads the text as stored rather than as a time.
+		var stored string
+		if err := db.QueryRow(`SELECT created_at || '' FROM mutations WHERE id = $1`, id).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored != want {
+			t.Errorf("%s created_at stored as %q, want %q", id, stored, want)
+		}
+	}
+
+	mutation, err := wr.GetMutationByID(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mutation.CreatedAt.Equal(createdAt) {
+		t.Errorf("m1 created at %s, want %s", mutation.CreatedAt, createdAt)
+	}
+}
+
+// helpers
+
+func openSQLiteTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "wallet.db")+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
@@ -0,0 +1,9 @@
+DROP TABLE postings;
+DROP TABLE journal_entries;
+DROP TABLE ledger_accounts;
+DROP TABLE fx_quotes;
+DROP TABLE fx_rates;
+DROP TABLE tokens;
+DROP TABLE holds;
+DROP TABLE mutations;
+DROP TABLE wallets;
@@ -0,0 +1,106 @@
+-- The schema MigratePostgres created before migrations were versioned.
+-- Databases from that time are recorded at this version without running
+-- this file.
+
+CREATE TABLE wallets (
+	id text primary key,
+	owned_by text not null,
+	status int not null,
+	updated_at timestamptz,
+	balance bigint not null,
+	held bigint not null default 0,
+	currency text not null default 'IDR'
+);
+CREATE INDEX wallets_owned_by_idx ON wallets (owned_by);
+
+CREATE TABLE mutations (
+	id text primary key,
+	reference_id text unique,
+	created_at timestamptz not null,
+	created_by text not null,
+	wallet_id text not null default '',
+	action int not null,
+	status int not null,
+	amount bigint not null,
+	currency text not null default 'IDR',
+	failure_reason text not null default '',
+	related_id text not null default '',
+	counterparty text not null default '',
+	reversed_amount bigint not null default 0,
+	fx_rate text not null default ''
+);
+CREATE INDEX mutations_wallet_id_created_at_idx ON mutations (wallet_id, created_at, id);
+
+CREATE TABLE holds (
+	id text primary key,
+	wallet_id text not null references wallets (id),
+	reference_id text not null unique,
+	status int not null,
+	amount bigint not null,
+	captured_amount bigint not null,
+	currency text not null default 'IDR',
+	mutation_id text not null,
+	created_at timestamptz not null,
+	expires_at timestamptz not null,
+	updated_at timestamptz
+);
+CREATE INDEX holds_wallet_id_status_idx ON holds (wallet_id, status);
+
+CREATE TABLE tokens (
+	id text primary key,
+	wallet_id text not null references wallets (id),
+	prefix text not null default '',
+	hash text not null unique,
+	scopes text not null default 'admin balance:read transactions:read deposit withdraw',
+	created_at timestamptz not null,
+	expires_at timestamptz,
+	revoked_at timestamptz
+);
+CREATE INDEX tokens_wallet_id_idx ON tokens (wallet_id);
+CREATE INDEX tokens_prefix_idx ON tokens (prefix);
+
+CREATE TABLE fx_rates (
+	id bigserial primary key,
+	base text not null,
+	quote text not null,
+	rate text not null,
+	effective_at timestamptz not null,
+	created_at timestamptz not null
+);
+CREATE INDEX fx_rates_pair_effective_at_idx ON fx_rates (base, quote, effective_at);
+
+CREATE TABLE fx_quotes (
+	id text primary key,
+	wallet_id text not null references wallets (id),
+	to_wallet_id text not null references wallets (id),
+	from_currency text not null,
+	to_currency text not null,
+	rate text not null,
+	amount bigint not null,
+	converted_amount bigint not null,
+	created_at timestamptz not null,
+	expires_at timestamptz not null,
+	used_at timestamptz
+);
+
+CREATE TABLE ledger_accounts (
+	id text primary key,
+	kind int not null,
+	name text not null
+);
+
+CREATE TABLE journal_entries (
+	id text primary key,
+	mutation_id text not null,
+	created_at timestamptz not null,
+	description text not null
+);
+
+CREATE TABLE postings (
+	id bigserial primary key,
+	entry_id text not null references journal_entries (id),
+	account_id text not null references ledger_accounts (id),
+	amount bigint not null,
+	currency text not null default 'IDR'
+);
+CREATE INDEX postings_account_id_idx ON postings (account_id);
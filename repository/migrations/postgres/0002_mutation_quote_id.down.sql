@@ -0,0 +1 @@
+ALTER TABLE mutations DROP COLUMN quote_id;
@@ -0,0 +1 @@
+-- Nothing to revert, see 0003_utc_timestamps.up.sql.
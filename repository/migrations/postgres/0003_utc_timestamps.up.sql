@@ -0,0 +1,2 @@
+-- timestamptz columns hold instants whatever zone they were written in, so
+-- only SQLite databases need their timestamps rewritten in UTC.
@@ -0,0 +1,108 @@
+-- The schema Migrate created before migrations were versioned. Databases
+-- from that time are upgraded to it in Go, see upgradeUnversioned, and
+-- recorded at this version without running this file.
+
+CREATE TABLE wallets (
+	id string primary key,
+	owned_by string not null,
+	status int not null,
+	updated_at timestamp,
+	balance integer not null,
+	held integer not null default 0,
+	currency string not null default 'IDR'
+);
+CREATE INDEX wallets_owned_by_idx ON wallets (owned_by);
+
+CREATE TABLE mutations (
+	id string primary key,
+	reference_id string unique,
+	created_at timestamp not null,
+	created_by string not null,
+	wallet_id string not null default '',
+	action int not null,
+	status int not null,
+	amount integer not null,
+	currency string not null default 'IDR',
+	failure_reason string not null default '',
+	related_id string not null default '',
+	counterparty string not null default '',
+	reversed_amount integer not null default 0,
+	fx_rate text not null default ''
+);
+CREATE INDEX mutations_wallet_id_created_at_idx ON mutations (wallet_id, created_at, id);
+
+CREATE TABLE holds (
+	id string primary key,
+	wallet_id string not null references wallets (id),
+	reference_id string not null unique,
+	status int not null,
+	amount integer not null,
+	captured_amount integer not null,
+	currency string not null default 'IDR',
+	mutation_id string not null,
+	created_at timestamp not null,
+	expires_at timestamp not null,
+	updated_at timestamp
+);
+CREATE INDEX holds_wallet_id_status_idx ON holds (wallet_id, status);
+
+CREATE TABLE tokens (
+	id string primary key,
+	wallet_id string not null references wallets (id),
+	prefix string not null default '',
+	hash string not null unique,
+	scopes string not null default 'admin balance:read transactions:read deposit withdraw',
+	created_at timestamp not null,
+	expires_at timestamp,
+	revoked_at timestamp
+);
+CREATE INDEX tokens_wallet_id_idx ON tokens (wallet_id);
+CREATE INDEX tokens_prefix_idx ON tokens (prefix);
+
+-- Rates are declared text: a string column has numeric affinity in SQLite
+-- and would store them as lossy reals.
+CREATE TABLE fx_rates (
+	id integer primary key,
+	base string not null,
+	quote string not null,
+	rate text not null,
+	effective_at timestamp not null,
+	created_at timestamp not null
+);
+CREATE INDEX fx_rates_pair_effective_at_idx ON fx_rates (base, quote, effective_at);
+
+CREATE TABLE fx_quotes (
+	id string primary key,
+	wallet_id string not null references wallets (id),
+	to_wallet_id string not null references wallets (id),
+	from_currency string not null,
+	to_currency string not null,
+	rate text not null,
+	amount integer not null,
+	converted_amount integer not null,
+	created_at timestamp not null,
+	expires_at timestamp not null,
+	used_at timestamp
+);
+
+CREATE TABLE ledger_accounts (
+	id string primary key,
+	kind int not null,
+	name string not null
+);
+
+CREATE TABLE journal_entries (
+	id string primary key,
+	mutation_id string not null,
+	created_at timestamp not null,
+	description string not null
+);
+
+CREATE TABLE postings (
+	id integer primary key,
+	entry_id string not null references journal_entries (id),
+	account_id string not null references ledger_accounts (id),
+	amount integer not null,
+	currency string not null default 'IDR'
+);
+CREATE INDEX postings_account_id_idx ON postings (account_id);
@@ -0,0 +1 @@
+ALTER TABLE mutations ADD COLUMN quote_id text not null default '';
@@ -0,0 +1,2 @@
+-- Timestamps in UTC read back as the same instants, so there is nothing to
+-- revert.
@@ -0,0 +1,59 @@
+-- Timestamps are compared as text, so they must all be in one zone. Those
+-- written in another zone than UTC, as 2006-01-02 15:04:05.999999999-07:00,
+-- are rewritten in UTC keeping their fraction of a second.
+
+UPDATE wallets
+SET updated_at = datetime(substr(updated_at, 1, 19) || substr(updated_at, -6)) || substr(updated_at, 20, length(updated_at) - 25) || '+00:00'
+WHERE substr(updated_at, -6, 1) IN ('+', '-') AND substr(updated_at, -6) <> '+00:00';
+
+UPDATE mutations
+SET created_at = datetime(substr(created_at, 1, 19) || substr(created_at, -6)) || substr(created_at, 20, length(created_at) - 25) || '+00:00'
+WHERE substr(created_at, -6, 1) IN ('+', '-') AND substr(created_at, -6) <> '+00:00';
+
+UPDATE holds
+SET created_at = datetime(substr(created_at, 1, 19) || substr(created_at, -6)) || substr(created_at, 20, length(created_at) - 25) || '+00:00'
+WHERE substr(created_at, -6, 1) IN ('+', '-') AND substr(created_at, -6) <> '+00:00';
+
+UPDATE holds
+SET expires_at = datetime(substr(expires_at, 1, 19) || substr(expires_at, -6)) || substr(expires_at, 20, length(expires_at) - 25) || '+00:00'
+WHERE substr(expires_at, -6, 1) IN ('+', '-') AND substr(expires_at, -6) <> '+00:00';
+
+UPDATE holds
+SET updated_at = datetime(substr(updated_at, 1, 19) || substr(updated_at, -6)) || substr(updated_at, 20, length(updated_at) - 25) || '+00:00'
+WHERE substr(updated_at, -6, 1) IN ('+', '-') AND substr(updated_at, -6) <> '+00:00';
+
+UPDATE tokens
+SET created_at = datetime(substr(created_at, 1, 19) || substr(created_at, -6)) || substr(created_at, 20, length(created_at) - 25) || '+00:00'
+WHERE substr(created_at, -6, 1) IN ('+', '-') AND substr(created_at, -6) <> '+00:00';
+
+UPDATE tokens
+SET expires_at = datetime(substr(expires_at, 1, 19) || substr(expires_at, -6)) || substr(expires_at, 20, length(expires_at) - 25) || '+00:00'
+WHERE substr(expires_at, -6, 1) IN ('+', '-') AND substr(expires_at, -6) <> '+00:00';
+
+UPDATE tokens
+SET revoked_at = datetime(substr(revoked_at, 1, 19) || substr(revoked_at, -6)) || substr(revoked_at, 20, length(revoked_at) - 25) || '+00:00'
+WHERE substr(revoked_at, -6, 1) IN ('+', '-') AND substr(revoked_at, -6) <> '+00:00';
+
+UPDATE fx_rates
+SET effective_at = datetime(substr(effective_at, 1, 19) || substr(effective_at, -6)) || substr(effective_at, 20, length(effective_at) - 25) || '+00:00'
+WHERE substr(effective_at, -6, 1) IN ('+', '-') AND substr(effective_at, -6) <> '+00:00';
+
+UPDATE fx_rates
+SET created_at = datetime(substr(created_at, 1, 19) || substr(created_at, -6)) || substr(created_at, 20, length(created_at) - 25) || '+00:00'
+WHERE substr(created_at, -6, 1) IN ('+', '-') AND substr(created_at, -6) <> '+00:00';
+
+UPDATE fx_quotes
+SET created_at = datetime(substr(created_at, 1, 19) || substr(created_at, -6)) || substr(created_at, 20, length(created_at) - 25) || '+00:00'
+WHERE substr(created_at, -6, 1) IN ('+', '-') AND substr(created_at, -6) <> '+00:00';
+
+UPDATE fx_quotes
+SET expires_at = datetime(substr(expires_at, 1, 19) || substr(expires_at, -6)) || substr(expires_at, 20, length(expires_at) - 25) || '+00:00'
+WHERE substr(expires_at, -6, 1) IN ('+', '-') AND substr(expires_at, -6) <> '+00:00';
+
+UPDATE fx_quotes
+SET used_at = datetime(substr(used_at, 1, 19) || substr(used_at, -6)) || substr(used_at, 20, length(used_at) - 25) || '+00:00'
+WHERE substr(used_at, -6, 1) IN ('+', '-') AND substr(used_at, -6) <> '+00:00';
+
+UPDATE journal_entries
+SET created_at = datetime(substr(created_at, 1, 19) || substr(created_at, -6)) || substr(created_at, 20, length(created_at) - 25) || '+00:00'
+WHERE substr(created_at, -6, 1) IN ('+', '-') AND substr(created_at, -6) <> '+00:00';
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+// The queries of walletRepository are written in SQL that SQLite and
+// Postgres both accept. What differs is the schema, see
+// migrations/postgres, and how concurrent updates of one wallet are kept
+// apart, see lockWallet.
+
+const (
+	lockWalletQuery = `
+		SELECT id
+		FROM wallets
+		WHERE id = $1
+		FOR UPDATE;
+	`
+)
+
+// NewPostgresWalletRepository returns a wallet repository for a Postgres
+// database set up by MigratePostgres. Balance and hold updates lock the
+// wallet row until their transaction ends, so requests against one wallet
+// queue up while other wallets are unaffected.
+func NewPostgresWalletRepository(db *sql.DB) types.WalletRepository {
+	return &walletRepository{
+		conn:     db,
+		db:       db,
+		lockRows: true,
+	}
+}
+
+// MigratePostgres brings a Postgres database up to the latest schema.
+func MigratePostgres(db *sql.DB) error {
+	_, err := NewPostgresMigrator(db).Up(context.Background())
+	return err
+}
@@ -0,0 +1,535 @@
+package repository
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+const (
+	// Tokens from before scopes, and those copied from the wallets table,
+	// could do everything. Keep in sync with types.AllScopes.
+	allScopes = string(types.ScopeAdmin) + " " +
+		string(types.ScopeBalanceRead) + " " +
+		string(types.ScopeTransactionsRead) + " " +
+		string(types.ScopeDeposit) + " " +
+		string(types.ScopeWithdraw)
+
+	createWalletsStmt = `CREATE TABLE IF NOT EXISTS wallets ` + walletsDefinition
+
+	walletsDefinition = `(
+			id string primary key,
+			owned_by string not null,
+			status int not null,
+			updated_at timestamp,
+			balance integer not null,
+			held integer not null default 0,
+			currency string not null default '` + types.DefaultCurrency + `'
+		);
+	`
+
+	createWalletsOwnedByIndexStmt = `
+		CREATE INDEX IF NOT EXISTS wallets_owned_by_idx ON wallets (owned_by);
+	`
+
+	createMutationsStmt = `
+		CREATE TABLE IF NOT EXISTS mutations (
+			id string primary key,
+			reference_id string unique,
+			created_at timestamp not null,
+			created_by string not null,
+			wallet_id string not null default '',
+			action int not null,
+			status int not null,
+			amount integer not null,
+			currency string not null default '` + types.DefaultCurrency + `',
+			failure_reason string not null default '',
+			related_id string not null default '',
+			counterparty string not null default '',
+			reversed_amount integer not null default 0,
+			fx_rate text not null default ''
+		);
+	`
+
+	createMutationsWalletIndexStmt = `
+		DROP INDEX IF EXISTS mutations_created_by_created_at_idx;
+		CREATE INDEX IF NOT EXISTS mutations_wallet_id_created_at_idx ON mutations (wallet_id, created_at, id);
+	`
+
+	backfillMutationWalletIDsStmt = `
+		UPDATE mutations
+		SET wallet_id = COALESCE((SELECT id FROM wallets WHERE wallets.owned_by = mutations.created_by), '')
+		WHERE wallet_id = '';
+	`
+
+	copyRebuiltWalletsStmt = `
+		INSERT INTO wallets_rebuilt (id, owned_by, status, updated_at, balance, held, currency)
+		SELECT id, owned_by, status, updated_at, balance, held, currency
+		FROM wallets;
+	`
+
+	// Each wallet of a database from before the tokens table keeps its
+	// one token, created at migration time. The token is copied into hash
+	// with an empty prefix until hashTokens hashes it.
+	copyWalletTokensStmt = `
+		INSERT INTO tokens (id, wallet_id, hash, created_at)
+		SELECT lower(hex(randomblob(16))), id, token, CURRENT_TIMESTAMP
+		FROM wallets;
+	`
+
+	// uniqueOwnedByIndexQuery counts unique indexes covering owned_by,
+	// which databases from before multiple wallets per customer have.
+	uniqueOwnedByIndexQuery = `
+		SELECT count(*)
+		FROM pragma_index_list('wallets') AS il, pragma_index_info(il.name) AS ii
+		WHERE il."unique" = 1 AND ii.name = 'owned_by';
+	`
+
+	createHoldsStmt = `
+		CREATE TABLE IF NOT EXISTS holds (
+			id string primary key,
+			wallet_id string not null references wallets (id),
+			reference_id string not null unique,
+			status int not null,
+			amount integer not null,
+			captured_amount integer not null,
+			currency string not null default '` + types.DefaultCurrency + `',
+			mutation_id string not null,
+			created_at timestamp not null,
+			expires_at timestamp not null,
+			updated_at timestamp
+		);
+		CREATE INDEX IF NOT EXISTS holds_wallet_id_status_idx ON holds (wallet_id, status);
+	`
+
+	// Rates are declared text: a string column has numeric affinity in
+	// SQLite and would store them as lossy reals.
+	createFXRatesStmt = `
+		CREATE TABLE IF NOT EXISTS fx_rates (
+			id integer primary key,
+			base string not null,
+			quote string not null,
+			rate text not null,
+			effective_at timestamp not null,
+			created_at timestamp not null
+		);
+		CREATE INDEX IF NOT EXISTS fx_rates_pair_effective_at_idx ON fx_rates (base, quote, effective_at);
+	`
+
+	createFXQuotesStmt = `
+		CREATE TABLE IF NOT EXISTS fx_quotes (
+			id string primary key,
+			wallet_id string not null references wallets (id),
+			to_wallet_id string not null references wallets (id),
+			from_currency string not null,
+			to_currency string not null,
+			rate text not null,
+			amount integer not null,
+			converted_amount integer not null,
+			created_at timestamp not null,
+			expires_at timestamp not null,
+			used_at timestamp
+		);
+	`
+
+	createTokensStmt = `
+		CREATE TABLE IF NOT EXISTS tokens (
+			id string primary key,
+			wallet_id string not null references wallets (id),
+			prefix string not null default '',
+			hash string not null unique,
+			scopes string not null default '` + allScopes + `',
+			created_at timestamp not null,
+			expires_at timestamp,
+			revoked_at timestamp
+		);
+		CREATE INDEX IF NOT EXISTS tokens_wallet_id_idx ON tokens (wallet_id);
+	`
+
+	createTokensPrefixIndexStmt = `
+		CREATE INDEX IF NOT EXISTS tokens_prefix_idx ON tokens (prefix);
+	`
+
+	listPlainTokensQuery = `
+		SELECT id, hash
+		FROM tokens
+		WHERE prefix = '';
+	`
+
+	hashTokenStmt = `
+		UPDATE tokens
+		SET prefix = $1, hash = $2
+		WHERE id = $3;
+	`
+
+	createLedgerAccountsStmt = `
+		CREATE TABLE IF NOT EXISTS ledger_accounts (
+			id string primary key,
+			kind int not null,
+			name string not null
+		);
+	`
+
+	createJournalEntriesStmt = `
+		CREATE TABLE IF NOT EXISTS journal_entries (
+			id string primary key,
+			mutation_id string not null,
+			created_at timestamp not null,
+			description string not null
+		);
+	`
+
+	createPostingsStmt = `
+		CREATE TABLE IF NOT EXISTS postings (
+			id integer primary key,
+			entry_id string not null references journal_entries (id),
+			account_id string not null references ledger_accounts (id),
+			amount integer not null,
+			currency string not null default '` + types.DefaultCurrency + `'
+		);
+		CREATE INDEX IF NOT EXISTS postings_account_id_idx ON postings (account_id);
+	`
+
+	backfillWalletLedgerAccountsStmt = `
+		INSERT INTO ledger_accounts (id, kind, name)
+		SELECT 'wallet:' || id, $1, 'Wallet of ' || owned_by
+		FROM wallets
+		WHERE true
+		ON CONFLICT (id) DO NOTHING;
+	`
+
+	backfillOpeningEntriesStmt = `
+		INSERT INTO journal_entries (id, mutation_id, created_at, description)
+		SELECT 'opening:' || id, '', $1, 'opening balance'
+		FROM wallets
+		WHERE balance != 0;
+	`
+
+	backfillOpeningWalletPostingsStmt = `
+		INSERT INTO postings (entry_id, account_id, amount, currency)
+		SELECT 'opening:' || id, 'wallet:' || id, balance, currency
+		FROM wallets
+		WHERE balance != 0;
+	`
+
+	backfillOpeningSystemPostingsStmt = `
+		INSERT INTO postings (entry_id, account_id, amount, currency)
+		SELECT 'opening:' || id, $1, -balance, currency
+		FROM wallets
+		WHERE balance != 0;
+	`
+
+	copyLegacyWalletsStmt = `
+		INSERT INTO wallets (id, owned_by, status, updated_at, balance)
+		SELECT id, owned_by, status, updated_at, CAST(ROUND(balance * $1) AS INTEGER)
+		FROM wallets_legacy;
+		INSERT INTO tokens (id, wallet_id, hash, created_at)
+		SELECT lower(hex(randomblob(16))), id, token, CURRENT_TIMESTAMP
+		FROM wallets_legacy;
+	`
+
+	copyLegacyMutationsStmt = `
+		INSERT INTO mutations (id, reference_id, created_at, created_by, wallet_id, action, status, amount)
+		SELECT
+			id, reference_id, created_at, created_by,
+			COALESCE((SELECT wallets.id FROM wallets WHERE wallets.owned_by = mutations_legacy.created_by), ''),
+			action, status, CAST(ROUND(amount * $1) AS INTEGER)
+		FROM mutations_legacy;
+	`
+)
+
+// upgradeUnversioned brings a SQLite database from before migrations were
+// versioned, written by any older version, to the schema of the first
+// migration.
+func upgradeUnversioned(tx *sql.Tx) error {
+	// Databases created before amounts were stored as integer minor units
+	// have real columns. SQLite cannot change a column type in place, so
+	// such tables are renamed, recreated and copied over with the values
+	// scaled to minor units.
+	legacyTables := []struct {
+		table    string
+		column   string
+		copyStmt string
+	}{
+		{"wallets", "balance", copyLegacyWalletsStmt},
+		{"mutations", "amount", copyLegacyMutationsStmt},
+	}
+
+	var (
+		toCopy []string
+		err    error
+	)
+	for _, lt := range legacyTables {
+		colType, err := columnType(tx, lt.table, lt.column)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(colType, "real") {
+			continue
+		}
+
+		if _, err = tx.Exec("ALTER TABLE " + lt.table + " RENAME TO " + lt.table + "_legacy;"); err != nil {
+			return err
+		}
+		toCopy = append(toCopy, lt.copyStmt, "DROP TABLE "+lt.table+"_legacy;")
+	}
+
+	if _, err = tx.Exec(createWalletsStmt); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(createMutationsStmt); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(createHoldsStmt); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(createTokensStmt); err != nil {
+		return err
+	}
+
+	// Tokens were stored in clear, in a token column, before they were
+	// hashed.
+	plainType, err := columnType(tx, "tokens", "token")
+	if err != nil {
+		return err
+	}
+	if plainType != "" {
+		for _, stmt := range []string{
+			"ALTER TABLE tokens RENAME COLUMN token TO hash;",
+			"ALTER TABLE tokens ADD COLUMN prefix string not null default '';",
+		} {
+			if _, err = tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err = tx.Exec(createTokensPrefixIndexStmt); err != nil {
+		return err
+	}
+
+	// Columns added after a table was first released, with the statement
+	// filling them in for existing rows, if any.
+	addedColumns := []struct {
+		table      string
+		column     string
+		definition string
+		backfill   string
+	}{
+		{"wallets", "held", "integer not null default 0", ""},
+		{"mutations", "failure_reason", "string not null default ''", ""},
+		{"mutations", "related_id", "string not null default ''", ""},
+		{"mutations", "counterparty", "string not null default ''", ""},
+		{"mutations", "reversed_amount", "integer not null default 0", ""},
+		// Until a customer could own several wallets, created_by
+		// identified the wallet.
+		{"mutations", "wallet_id", "string not null default ''", backfillMutationWalletIDsStmt},
+		// Everything was in the default currency before wallets had one.
+		{"wallets", "currency", "string not null default '" + types.DefaultCurrency + "'", ""},
+		{"mutations", "currency", "string not null default '" + types.DefaultCurrency + "'", ""},
+		{"holds", "currency", "string not null default '" + types.DefaultCurrency + "'", ""},
+		{"mutations", "fx_rate", "text not null default ''", ""},
+		{"tokens", "scopes", "string not null default '" + allScopes + "'", ""},
+	}
+
+	for _, ac := range addedColumns {
+		colType, err := columnType(tx, ac.table, ac.column)
+		if err != nil {
+			return err
+		}
+		if colType != "" {
+			continue
+		}
+
+		if _, err = tx.Exec("ALTER TABLE " + ac.table + " ADD COLUMN " + ac.column + " " + ac.definition + ";"); err != nil {
+			return err
+		}
+
+		if ac.backfill == "" {
+			continue
+		}
+		if _, err = tx.Exec(ac.backfill); err != nil {
+			return err
+		}
+	}
+
+	scale := math.Pow10(types.DefaultCurrencyExponent)
+	for _, stmt := range toCopy {
+		if _, err = tx.Exec(stmt, scale); err != nil {
+			return err
+		}
+	}
+
+	if err = migrateWalletsTable(tx); err != nil {
+		return err
+	}
+
+	if err = hashTokens(tx); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(createMutationsWalletIndexStmt); err != nil {
+		return err
+	}
+
+	// The quotes table references wallets, so it is created only once the
+	// wallets table has its final name.
+	for _, stmt := range []string{createFXRatesStmt, createFXQuotesStmt} {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return migrateLedger(tx)
+}
+
+// migrateWalletsTable lifts the one-wallet-per-customer restriction of
+// older databases and moves their tokens to the tokens table. SQLite cannot
+// drop a UNIQUE constraint or column, so the wallets table is rebuilt under
+// a new name and swapped in; renaming the new table rather than the old one
+// keeps the references from other tables intact.
+func migrateWalletsTable(tx *sql.Tx) error {
+	var uniqueIndexes int
+	if err := tx.QueryRow(uniqueOwnedByIndexQuery).Scan(&uniqueIndexes); err != nil {
+		return err
+	}
+
+	tokenType, err := columnType(tx, "wallets", "token")
+	if err != nil {
+		return err
+	}
+
+	if tokenType != "" {
+		if _, err = tx.Exec(copyWalletTokensStmt); err != nil {
+			return err
+		}
+	}
+
+	if uniqueIndexes > 0 || tokenType != "" {
+		for _, stmt := range []string{
+			"CREATE TABLE wallets_rebuilt " + walletsDefinition,
+			copyRebuiltWalletsStmt,
+			"DROP TABLE wallets;",
+			"ALTER TABLE wallets_rebuilt RENAME TO wallets;",
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(createWalletsOwnedByIndexStmt)
+	return err
+}
+
+// hashTokens replaces every token still stored in clear, recognisable by
+// its empty prefix, with its prefix and salted hash.
+func hashTokens(tx *sql.Tx) error {
+	rows, err := tx.Query(listPlainTokensQuery)
+	if err != nil {
+		return err
+	}
+
+	plain := map[string]string{}
+	for rows.Next() {
+		var id, token string
+		if err = rows.Scan(&id, &token); err != nil {
+			rows.Close()
+			return err
+		}
+		plain[id] = token
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for id, token := range plain {
+		hash, err := types.HashToken(token)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(hashTokenStmt, types.TokenPrefix(token), hash, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateLedger creates the double-entry ledger tables and system accounts.
+// When the ledger is introduced into a database that already holds wallets,
+// every existing balance is booked as an opening entry against the opening
+// balance account so that wallet accounts match wallet balances from the
+// start.
+func migrateLedger(tx *sql.Tx) error {
+	ledgerExisted, err := columnType(tx, "journal_entries", "id")
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{createLedgerAccountsStmt, createJournalEntriesStmt, createPostingsStmt} {
+		if _, err = tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	// Postings made before wallets had a currency are in the default one.
+	currencyType, err := columnType(tx, "postings", "currency")
+	if err != nil {
+		return err
+	}
+	if currencyType == "" {
+		if _, err = tx.Exec("ALTER TABLE postings ADD COLUMN currency string not null default '" + types.DefaultCurrency + "';"); err != nil {
+			return err
+		}
+	}
+
+	for _, account := range types.SystemLedgerAccounts {
+		if _, err = tx.Exec(createLedgerAccountQuery, account.ID, account.Kind, account.Name); err != nil {
+			return err
+		}
+	}
+
+	if ledgerExisted != "" {
+		return nil
+	}
+
+	if _, err = tx.Exec(backfillWalletLedgerAccountsStmt, types.LedgerAccountKindWallet); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(backfillOpeningEntriesStmt, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(backfillOpeningWalletPostingsStmt); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(backfillOpeningSystemPostingsStmt, types.LedgerAccountOpeningBalance)
+	return err
+}
+
+// columnType returns the declared type of a column, or an empty string if
+// the table or column does not exist yet.
+func columnType(tx *sql.Tx, table, column string) (string, error) {
+	var colType string
+	err := tx.QueryRow(
+		"SELECT type FROM pragma_table_info($1) WHERE name = $2;",
+		table,
+		column,
+	).Scan(&colType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return colType, err
+}
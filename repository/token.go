@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+const (
+	createTokenQuery = `
+		INSERT INTO tokens (id, wallet_id, prefix, hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6);
+	`
+
+	// Tokens expire in UTC so that the text comparison in SQLite is
+	// consistent.
+	listValidTokensByPrefixQuery = `
+		SELECT id, wallet_id, prefix, hash, scopes, created_at, expires_at, revoked_at
+		FROM tokens
+		WHERE
+			prefix = $1
+			AND revoked_at IS NULL
+			AND (expires_at IS NULL OR expires_at > $2);
+	`
+
+	listTokensByWalletIDQuery = `
+		SELECT id, wallet_id, prefix, hash, scopes, created_at, expires_at, revoked_at
+		FROM tokens
+		WHERE wallet_id = $1
+		ORDER BY created_at, id;
+	`
+
+	expireTokenQuery = `
+		UPDATE tokens
+		SET expires_at = $1
+		WHERE
+			id = $2
+			AND (expires_at IS NULL OR expires_at > $1);
+	`
+
+	revokeTokenQuery = `
+		UPDATE tokens
+		SET revoked_at = COALESCE(revoked_at, $1)
+		WHERE id = $2;
+	`
+)
+
+// CreateToken stores times in UTC, see listValidTokensByPrefixQuery.
+func (wr *walletRepository) CreateToken(ctx context.Context, req types.Token) error {
+	_, err := wr.db.ExecContext(ctx,
+		createTokenQuery,
+		req.ID,
+		req.WalletID,
+		req.Prefix,
+		req.Hash,
+		req.Scopes,
+		req.CreatedAt.UTC(),
+	)
+
+	return err
+}
+
+// GetToken narrows the candidates down by prefix and returns the one whose
+// hash matches the token.
+func (wr *walletRepository) GetToken(ctx context.Context, token string) (types.Token, error) {
+	rows, err := wr.db.QueryContext(ctx, listValidTokensByPrefixQuery, types.TokenPrefix(token), time.Now().UTC())
+	if err != nil {
+		return types.Token{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data types.Token
+		if err := scanToken(rows, &data); err != nil {
+			return types.Token{}, err
+		}
+
+		if data.Matches(token) {
+			return data, nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return types.Token{}, err
+	}
+
+	return types.Token{}, types.ErrTokenNotFound
+}
+
+func (wr *walletRepository) ListTokens(ctx context.Context, walletID string) ([]types.Token, error) {
+	rows, err := wr.db.QueryContext(ctx, listTokensByWalletIDQuery, walletID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []types.Token
+	for rows.Next() {
+		var token types.Token
+		if err := scanToken(rows, &token); err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (wr *walletRepository) ExpireToken(ctx context.Context, id string, at time.Time) error {
+	_, err := wr.db.ExecContext(ctx, expireTokenQuery, at.UTC(), id)
+
+	return err
+}
+
+func (wr *walletRepository) RevokeToken(ctx context.Context, id string, at time.Time) error {
+	_, err := wr.db.ExecContext(ctx, revokeTokenQuery, at.UTC(), id)
+
+	return err
+}
+
+// helpers
+
+func scanToken(row rowScanner, data *types.Token) error {
+	return row.Scan(
+		&data.ID,
+		&data.WalletID,
+		&data.Prefix,
+		&data.Hash,
+		&data.Scopes,
+		&data.CreatedAt,
+		&data.ExpiresAt,
+		&data.RevokedAt,
+	)
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func testGetByTokenOnlyAcceptsValidTokens(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	now := time.Now()
+	newToken := func() testToken {
+		return createTestToken(t, wr, wallet.ID, uuid.NewString(), now)
+	}
+
+	active, graced, expired, revoked := newToken(), newToken(), newToken(), newToken()
+	if err := wr.ExpireToken(ctx, graced.ID, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.ExpireToken(ctx, expired.ID, now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.RevokeToken(ctx, revoked.ID, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []testToken{active, graced} {
+		got, err := wr.GetByToken(ctx, token.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != wallet.ID {
+			t.Errorf("wallet of token = %s, want %s", got.ID, wallet.ID)
+		}
+	}
+
+	for _, token := range []testToken{expired, revoked} {
+		if _, err := wr.GetByToken(ctx, token.value); err != sql.ErrNoRows {
+			t.Errorf("token %s: got %v, want %v", token.ID, err, sql.ErrNoRows)
+		}
+		if _, err := wr.GetToken(ctx, token.value); err != types.ErrTokenNotFound {
+			t.Errorf("token %s: got %v, want %v", token.ID, err, types.ErrTokenNotFound)
+		}
+	}
+
+	// Expiring never extends a token that already expires sooner.
+	if err := wr.ExpireToken(ctx, expired.ID, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wr.GetByToken(ctx, expired.value); err != sql.ErrNoRows {
+		t.Errorf("expired token revived: got %v", err)
+	}
+}
+
+func testGetTokenVerifiesHashAmongSharedPrefix(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	first := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+	second := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	now := time.Now()
+	a := createTestToken(t, wr, first.ID, "samepfx-first", now)
+	b := createTestToken(t, wr, second.ID, "samepfx-second", now)
+	if a.Prefix != b.Prefix {
+		t.Fatalf("prefixes differ: %s, %s", a.Prefix, b.Prefix)
+	}
+
+	for _, token := range []testToken{a, b} {
+		got, err := wr.GetToken(ctx, token.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != token.ID || got.WalletID != token.WalletID {
+			t.Errorf("GetToken(%q) = %s of %s, want %s of %s", token.value, got.ID, got.WalletID, token.ID, token.WalletID)
+		}
+		if got.Hash == token.value {
+			t.Errorf("token %s stored in clear", token.ID)
+		}
+	}
+
+	if _, err := wr.GetToken(ctx, "samepfx-third"); err != types.ErrTokenNotFound {
+		t.Errorf("unknown token with known prefix: got %v, want %v", err, types.ErrTokenNotFound)
+	}
+}
+
+type testToken struct {
+	types.Token
+	value string
+}
+
+func createTestToken(t *testing.T, wr types.WalletRepository, walletID, value string, now time.Time) testToken {
+	t.Helper()
+	ctx := context.Background()
+
+	hash, err := types.HashToken(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := types.Token{
+		ID:        uuid.NewString(),
+		WalletID:  walletID,
+		Prefix:    types.TokenPrefix(value),
+		Hash:      hash,
+		CreatedAt: now,
+	}
+	if err = wr.CreateToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	return testToken{Token: token, value: value}
+}
+
+func testTokenScopesRoundTrip(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	hash, err := types.HashToken("scoped-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := types.Token{
+		ID:        uuid.NewString(),
+		WalletID:  wallet.ID,
+		Prefix:    types.TokenPrefix("scoped-token"),
+		Hash:      hash,
+		Scopes:    types.Scopes{types.ScopeBalanceRead, types.ScopeTransactionsRead},
+		CreatedAt: time.Now(),
+	}
+	if err = wr.CreateToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := wr.GetToken(ctx, "scoped-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Scopes.String() != token.Scopes.String() {
+		t.Errorf("scopes = %q, want %q", got.Scopes, token.Scopes)
+	}
+	if !got.Scopes.Has(types.ScopeBalanceRead) || got.Scopes.Has(types.ScopeWithdraw) {
+		t.Errorf("scopes %q grant the wrong permissions", got.Scopes)
+	}
+}
@@ -1,19 +1,38 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	types "github.com/otnayrus/simple-wallet-app/types/wallet"
 )
 
+// dbtx is the part of *sql.DB and *sql.Tx used by walletRepository, so the
+// same methods can run directly or inside a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type walletRepository struct {
-	db *sql.DB
+	conn *sql.DB
+	db   dbtx
+	// lockRows is set for databases with row locks, see lockWallet.
+	lockRows bool
 }
 
 const (
 	createWalletQuery = `
-		INSERT INTO wallets (id, owned_by, token, status, updated_at, balance)
+		INSERT INTO wallets (id, owned_by, status, updated_at, balance, currency)
 		VALUES ($1, $2, $3, $4, $5, $6);
 	`
 
@@ -23,175 +42,335 @@ const (
 			status = $1,
 			updated_at = $2
 		WHERE
-			token = $3
-		RETURNING id, owned_by, token, status, updated_at, balance;
+			id = $3
+		RETURNING id, owned_by, status, updated_at, balance, held, currency;
+	`
+
+	getWalletByIDQuery = `
+		SELECT id, owned_by, status, updated_at, balance, held, currency
+		FROM wallets
+		WHERE id = $1;
 	`
 
-	getWalletByTokenQuery = `
-		SELECT id, owned_by, token, status, updated_at, balance
+	listWalletsByOwnedByQuery = `
+		SELECT id, owned_by, status, updated_at, balance, held, currency
 		FROM wallets
-		WHERE token = $1;
+		WHERE owned_by = $1
+		ORDER BY id;
 	`
 
-	setWalletBalanceByTokenQuery = `
+	// The bounds on $1 are written so that checking them cannot overflow
+	// where adding it to the balance would.
+	addWalletBalanceByIDQuery = `
 		UPDATE wallets
 		SET
-			balance = $1,
+			balance = balance + $1,
 			updated_at = $2
 		WHERE
-			token = $3;
+			id = $3
+			AND status = $4
+			AND $1 >= held - balance
+			AND $1 <= 9223372036854775807 - balance
+		RETURNING id, owned_by, status, updated_at, balance, held, currency;
 	`
 
 	createMutationQuery = `
-		INSERT INTO mutations (id, reference_id, created_at, created_by, action, status, amount)
-		VALUES ($1, $2, $3, $4, $5, $6, $7);
+		INSERT INTO mutations (id, reference_id, created_at, created_by, wallet_id, action, status, amount, currency, failure_reason, related_id, counterparty, fx_rate, quote_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);
 	`
 
-	getMutationListQuery = `
-		SELECT id, reference_id, created_at, created_by, action, status, amount
+	getMutationByReferenceIDQuery = `
+		SELECT id, reference_id, created_at, created_by, wallet_id, action, status, amount, currency, failure_reason, related_id, counterparty, reversed_amount, fx_rate, quote_id
 		FROM mutations
-		WHERE created_by = $1
-		ORDER BY created_at DESC;
+		WHERE reference_id = $1;
+	`
+
+	getMutationByIDQuery = `
+		SELECT id, reference_id, created_at, created_by, wallet_id, action, status, amount, currency, failure_reason, related_id, counterparty, reversed_amount, fx_rate, quote_id
+		FROM mutations
+		WHERE id = $1;
+	`
+
+	addMutationReversedAmountQuery = `
+		UPDATE mutations
+		SET reversed_amount = reversed_amount + $1
+		WHERE
+			id = $2
+			AND status = $3
+			AND reversed_amount + $1 <= amount;
 	`
+
+	// getMutationListQuery is completed by buildMutationListQuery with
+	// the filter conditions, ordering and limit.
+	getMutationListQuery = `
+		SELECT id, reference_id, created_at, created_by, wallet_id, action, status, amount, currency, failure_reason, related_id, counterparty, reversed_amount, fx_rate, quote_id
+		FROM mutations
+		WHERE wallet_id = $1`
 )
 
 func NewWalletRepositiory(db *sql.DB) types.WalletRepository {
 	return &walletRepository{
-		db: db,
+		conn: db,
+		db:   db,
 	}
 }
 
-func (wr *walletRepository) Create(req types.Wallet) error {
-	_, err := wr.db.Exec(
-		createWalletQuery,
-		req.ID,
-		req.OwnedBy,
-		req.Token,
-		req.Status,
-		req.UpdatedAt,
-		req.Balance,
-	)
-	return err
+// WithinTransaction runs fn with a repository whose methods all share one
+// transaction, committing if fn returns nil and rolling back otherwise.
+// Calling it on a repository that is already transactional joins the
+// existing transaction.
+func (wr *walletRepository) WithinTransaction(ctx context.Context, fn func(types.WalletRepository) error) error {
+	return wr.withinTransaction(ctx, func(tr *walletRepository) error {
+		return fn(tr)
+	})
 }
 
-func (wr *walletRepository) Enable(token string) (types.Wallet, error) {
+// Ledger returns a ledger repository that shares this repository's
+// connection or transaction.
+func (wr *walletRepository) Ledger() types.LedgerRepository {
+	return &ledgerRepository{
+		db: wr.db,
+	}
+}
+
+// FX returns an FX repository that shares this repository's connection or
+// transaction.
+func (wr *walletRepository) FX() types.FXRepository {
+	return &fxRepository{
+		db: wr.db,
+	}
+}
+
+// Create stores the wallet together with its ledger account.
+func (wr *walletRepository) Create(ctx context.Context, req types.Wallet) error {
+	return wr.withinTransaction(ctx, func(tr *walletRepository) error {
+		_, err := tr.db.ExecContext(ctx,
+			createWalletQuery,
+			req.ID,
+			req.OwnedBy,
+			req.Status,
+			req.UpdatedAt,
+			req.Balance,
+			req.Currency,
+		)
+		if err != nil {
+			return err
+		}
+
+		return tr.Ledger().CreateAccount(ctx, types.NewWalletLedgerAccount(req))
+	})
+}
+
+func (wr *walletRepository) Enable(ctx context.Context, id string) (types.Wallet, error) {
 	var data types.Wallet
-	err := wr.db.QueryRow(
+	err := scanWallet(wr.db.QueryRowContext(ctx,
 		updateWalletStatusQuery,
 		types.StatusActive,
-		time.Now(),
-		token,
-	).Scan(
-		&data.ID,
-		&data.OwnedBy,
-		&data.Token,
-		&data.Status,
-		&data.UpdatedAt,
-		&data.Balance,
-	)
+		time.Now().UTC(),
+		id,
+	), &data)
 
 	return data, err
 }
 
-func (wr *walletRepository) GetByToken(token string) (types.Wallet, error) {
+// GetByToken finds the token by its prefix and verifies it against the
+// stored hash before loading the wallet.
+func (wr *walletRepository) GetByToken(ctx context.Context, token string) (types.Wallet, error) {
+	data, err := wr.GetToken(ctx, token)
+	if err == types.ErrTokenNotFound {
+		return types.Wallet{}, sql.ErrNoRows
+	}
+	if err != nil {
+		return types.Wallet{}, err
+	}
+
+	return wr.GetByID(ctx, data.WalletID)
+}
+
+func (wr *walletRepository) GetByID(ctx context.Context, id string) (types.Wallet, error) {
 	var data types.Wallet
-	err := wr.db.QueryRow(getWalletByTokenQuery, token).Scan(
-		&data.ID,
-		&data.OwnedBy,
-		&data.Token,
-		&data.Status,
-		&data.UpdatedAt,
-		&data.Balance,
-	)
+	err := scanWallet(wr.db.QueryRowContext(ctx, getWalletByIDQuery, id), &data)
 
 	return data, err
 }
 
-func (wr *walletRepository) Disable(token string) (types.Wallet, error) {
+func (wr *walletRepository) ListByOwnedBy(ctx context.Context, ownedBy string) ([]types.Wallet, error) {
+	rows, err := wr.db.QueryContext(ctx, listWalletsByOwnedByQuery, ownedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wallets []types.Wallet
+	for rows.Next() {
+		var wallet types.Wallet
+		if err := scanWallet(rows, &wallet); err != nil {
+			return nil, err
+		}
+
+		wallets = append(wallets, wallet)
+	}
+
+	return wallets, rows.Err()
+}
+
+func (wr *walletRepository) Disable(ctx context.Context, id string) (types.Wallet, error) {
 	var data types.Wallet
-	err := wr.db.QueryRow(
+	err := scanWallet(wr.db.QueryRowContext(ctx,
 		updateWalletStatusQuery,
 		types.StatusInactive,
-		time.Now(),
-		token,
-	).Scan(
-		&data.ID,
-		&data.OwnedBy,
-		&data.Token,
-		&data.Status,
-		&data.UpdatedAt,
-		&data.Balance,
-	)
+		time.Now().UTC(),
+		id,
+	), &data)
 
 	return data, err
 }
 
-func (wr *walletRepository) SetWalletBalanceByToken(balance float64, token string) error {
-	_, err := wr.db.Exec(
-		setWalletBalanceByTokenQuery,
-		balance,
-		time.Now(),
-		token,
-	)
+// AddWalletBalanceByID changes the balance relative to its stored value,
+// and only while the wallet is active and the result is not negative, so
+// the funds check and the write cannot interleave with another request.
+func (wr *walletRepository) AddWalletBalanceByID(ctx context.Context, delta types.Money, id string) (types.Wallet, error) {
+	var data types.Wallet
+	err := wr.withinTransaction(ctx, func(tr *walletRepository) error {
+		if err := tr.lockWallet(ctx, id); err != nil {
+			return err
+		}
 
-	return err
+		err := scanWallet(tr.db.QueryRowContext(ctx,
+			addWalletBalanceByIDQuery,
+			delta,
+			time.Now().UTC(),
+			id,
+			types.StatusActive,
+		), &data)
+		if err == sql.ErrNoRows {
+			err = tr.balanceUpdateFailureReason(ctx, getWalletStatusByIDQuery, id)
+			// Only an overflowing balance turns a credit down.
+			if err == types.ErrInsufficientFunds && delta.IsPositive() {
+				return types.ErrBalanceOverflow
+			}
+		}
+
+		return err
+	})
+	if err != nil {
+		return types.Wallet{}, err
+	}
+
+	return data, nil
+}
+
+// LockWallets takes the lock of lockWallet on each wallet in ID order.
+func (wr *walletRepository) LockWallets(ctx context.Context, ids ...string) error {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+
+	for _, id := range sorted {
+		if err := wr.lockWallet(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (wr *walletRepository) CreateMutation(req types.Mutation) error {
-	_, err := wr.db.Exec(
+func (wr *walletRepository) CreateMutation(ctx context.Context, req types.Mutation) error {
+	_, err := wr.db.ExecContext(ctx,
 		createMutationQuery,
 		req.ID,
 		req.ReferenceID,
-		req.CreatedAt,
+		req.CreatedAt.UTC(),
 		req.CreatedBy,
+		req.WalletID,
 		req.Action,
 		req.Status,
 		req.Amount,
+		req.Currency,
+		req.FailureReason,
+		req.RelatedID,
+		req.Counterparty,
+		req.FXRate,
+		req.QuoteID,
 	)
+	if isUniqueViolation(err, "mutations", "reference_id") {
+		return duplicateReference(req.ReferenceID)
+	}
 
 	return err
 }
 
-func (wr *walletRepository) Mutate(req types.Mutation, expectedBalance float64, token string) error {
-	tx, err := wr.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+func (wr *walletRepository) GetMutationByReferenceID(ctx context.Context, referenceID string) (types.Mutation, error) {
+	var data types.Mutation
+	err := scanMutation(wr.db.QueryRowContext(ctx, getMutationByReferenceIDQuery, referenceID), &data)
+
+	return data, err
+}
+
+func (wr *walletRepository) GetMutationByID(ctx context.Context, id string) (types.Mutation, error) {
+	var data types.Mutation
+	err := scanMutation(wr.db.QueryRowContext(ctx, getMutationByIDQuery, id), &data)
+
+	return data, err
+}
 
-	err = wr.CreateMutation(req)
+func (wr *walletRepository) AddReversedAmount(ctx context.Context, id string, amount types.Money) error {
+	res, err := wr.db.ExecContext(ctx,
+		addMutationReversedAmountQuery,
+		amount,
+		id,
+		types.MutationStatusSuccess,
+	)
 	if err != nil {
 		return err
 	}
 
-	err = wr.SetWalletBalanceByToken(expectedBalance, token)
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return types.ErrReversalExceedsAmount
+	}
 
-	if err = tx.Commit(); err != nil {
-		return err
+	return nil
+}
+
+// Mutate records the mutation, applies its amount to the wallet and posts
+// the matching journal entry in a single transaction.
+func (wr *walletRepository) Mutate(ctx context.Context, req types.Mutation) (types.Wallet, error) {
+	var data types.Wallet
+	err := wr.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		var err error
+		data, err = repo.AddWalletBalanceByID(ctx, req.BalanceDelta(), req.WalletID)
+		if err != nil {
+			return err
+		}
+
+		if err = repo.CreateMutation(ctx, req); err != nil {
+			return err
+		}
+
+		return repo.Ledger().PostJournalEntry(ctx, types.NewMutationJournalEntry(req, data.ID))
+	})
+	if err != nil {
+		return types.Wallet{}, err
 	}
 
-	return err
+	return data, nil
 }
 
-func (wr *walletRepository) ListMutation(ownerID string) ([]types.Mutation, error) {
-	rows, err := wr.db.Query(getMutationListQuery, ownerID)
+func (wr *walletRepository) ListMutation(ctx context.Context, filter types.MutationFilter) ([]types.Mutation, error) {
+	query, args := buildMutationListQuery(filter)
+	rows, err := wr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	var mutations []types.Mutation
 	for rows.Next() {
 		var mutation types.Mutation
-		err := rows.Scan(
-			&mutation.ID,
-			&mutation.ReferenceID,
-			&mutation.CreatedAt,
-			&mutation.CreatedBy,
-			&mutation.Action,
-			&mutation.Status,
-			&mutation.Amount,
-		)
+		err := scanMutation(rows, &mutation)
 		if err != nil {
 			return nil, err
 		}
@@ -199,5 +378,177 @@ func (wr *walletRepository) ListMutation(ownerID string) ([]types.Mutation, erro
 		mutations = append(mutations, mutation)
 	}
 
-	return mutations, err
+	return mutations, rows.Err()
+}
+
+// helpers
+
+func (wr *walletRepository) withinTransaction(ctx context.Context, fn func(*walletRepository) error) error {
+	if _, ok := wr.db.(*sql.Tx); ok {
+		return fn(wr)
+	}
+
+	tx, err := wr.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(&walletRepository{
+		conn:     wr.conn,
+		db:       tx,
+		lockRows: wr.lockRows,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockWallet locks the wallet row until the transaction ends, so the
+// checks and writes that follow cannot interleave with another request on
+// the same wallet. SQLite has no row locks and needs none: its
+// transactions begin immediately and exclude each other entirely.
+func (wr *walletRepository) lockWallet(ctx context.Context, id string) error {
+	if !wr.lockRows {
+		return nil
+	}
+
+	var locked string
+	return wr.db.QueryRowContext(ctx, lockWalletQuery, id).Scan(&locked)
+}
+
+// isUniqueViolation reports whether err is the unique constraint on
+// table.column failing, in SQLite or Postgres. Postgres names the
+// constraint of a column declared unique <table>_<column>_key.
+func isUniqueViolation(err error, table, column string) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" && pqErr.Constraint == table+"_"+column+"_key"
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && strings.HasSuffix(sqliteErr.Error(), " "+table+"."+column)
+	}
+
+	return false
+}
+
+func duplicateReference(referenceID string) error {
+	return fmt.Errorf("%w: %q", types.ErrDuplicateReference, referenceID)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanWallet reads amounts at the scale of the wallet currency.
+func scanWallet(row rowScanner, data *types.Wallet) error {
+	err := row.Scan(
+		&data.ID,
+		&data.OwnedBy,
+		&data.Status,
+		&data.UpdatedAt,
+		&data.Balance,
+		&data.Held,
+		&data.Currency,
+	)
+
+	exponent := types.CurrencyExponent(data.Currency)
+	data.Balance.Exponent, data.Held.Exponent = exponent, exponent
+	return err
+}
+
+func scanMutation(row rowScanner, data *types.Mutation) error {
+	err := row.Scan(
+		&data.ID,
+		&data.ReferenceID,
+		&data.CreatedAt,
+		&data.CreatedBy,
+		&data.WalletID,
+		&data.Action,
+		&data.Status,
+		&data.Amount,
+		&data.Currency,
+		&data.FailureReason,
+		&data.RelatedID,
+		&data.Counterparty,
+		&data.ReversedAmount,
+		&data.FXRate,
+		&data.QuoteID,
+	)
+
+	exponent := types.CurrencyExponent(data.Currency)
+	data.Amount.Exponent, data.ReversedAmount.Exponent = exponent, exponent
+	return err
+}
+
+// balanceUpdateFailureReason explains why a conditional balance update
+// matched no row, given a query selecting the wallet status by key.
+func (wr *walletRepository) balanceUpdateFailureReason(ctx context.Context, statusQuery, key string) error {
+	var status int
+	err := wr.db.QueryRowContext(ctx, statusQuery, key).Scan(&status)
+	if err != nil {
+		return err
+	}
+
+	if status != int(types.StatusActive) {
+		return types.ErrWalletInactive
+	}
+
+	return types.ErrInsufficientFunds
+}
+
+// buildMutationListQuery appends a condition for every set field of the
+// filter. Pages are taken with a keyset on (created_at, id) so that rows
+// inserted between requests neither repeat nor go missing.
+func buildMutationListQuery(filter types.MutationFilter) (string, []interface{}) {
+	var query strings.Builder
+	query.WriteString(getMutationListQuery)
+	args := []interface{}{filter.WalletID}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(filter.Actions) > 0 {
+		placeholders := make([]string, len(filter.Actions))
+		for i, action := range filter.Actions {
+			placeholders[i] = arg(int(action))
+		}
+		query.WriteString(" AND action IN (" + strings.Join(placeholders, ", ") + ")")
+	}
+	if filter.Status != 0 {
+		query.WriteString(" AND status = " + arg(int(filter.Status)))
+	}
+	if filter.MinAmount != nil {
+		query.WriteString(" AND amount >= " + arg(*filter.MinAmount))
+	}
+	if filter.MaxAmount != nil {
+		query.WriteString(" AND amount <= " + arg(*filter.MaxAmount))
+	}
+	// created_at is written in UTC and SQLite compares timestamps as
+	// text, so the bounds must use the same zone.
+	if !filter.From.IsZero() {
+		query.WriteString(" AND created_at >= " + arg(filter.From.UTC()))
+	}
+	if !filter.To.IsZero() {
+		query.WriteString(" AND created_at < " + arg(filter.To.UTC()))
+	}
+	if filter.ReferenceID != "" {
+		query.WriteString(" AND reference_id = " + arg(filter.ReferenceID))
+	}
+	comparison, order := "<", "DESC"
+	if filter.Ascending {
+		comparison, order = ">", "ASC"
+	}
+	if filter.AfterID != "" {
+		query.WriteString(" AND (created_at, id) " + comparison + " (SELECT created_at, id FROM mutations WHERE id = " + arg(filter.AfterID) + ")")
+	}
+
+	query.WriteString(" ORDER BY created_at " + order + ", id " + order + " LIMIT " + arg(filter.Limit) + ";")
+	return query.String(), args
 }
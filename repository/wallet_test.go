@@ -0,0 +1,392 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otnayrus/simple-wallet-app/service"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func createActiveWallet(t *testing.T, wr types.WalletRepository, balance types.Money) types.Wallet {
+	t.Helper()
+	ctx := context.Background()
+
+	wallet := types.Wallet{
+		ID:       uuid.NewString(),
+		OwnedBy:  uuid.NewString(),
+		Status:   int(types.StatusActive),
+		Balance:  balance,
+		Currency: types.DefaultCurrency,
+	}
+	if err := wr.Create(ctx, wallet); err != nil {
+		t.Fatal(err)
+	}
+
+	return wallet
+}
+
+func newMutation(wallet types.Wallet, action types.MutationAction, amount types.Money) types.Mutation {
+	return types.Mutation{
+		ID:          uuid.NewString(),
+		ReferenceID: uuid.NewString(),
+		CreatedAt:   time.Now(),
+		CreatedBy:   wallet.OwnedBy,
+		WalletID:    wallet.ID,
+		Action:      int(action),
+		Status:      int(types.MutationStatusSuccess),
+		Amount:      amount,
+		Currency:    types.DefaultCurrency,
+	}
+}
+
+func testMutateConcurrentWithdrawalsNeverOverdraw(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(10000, types.DefaultCurrencyExponent))
+
+	const attempts = 50
+	amount := types.NewMoney(1000, types.DefaultCurrencyExponent)
+
+	var (
+		wg                sync.WaitGroup
+		mu                sync.Mutex
+		succeeded, denied int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := wr.Mutate(ctx, newMutation(wallet, types.MutationActionWithdraw, amount))
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				succeeded++
+			case types.ErrInsufficientFunds:
+				denied++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 10 || denied != attempts-10 {
+		t.Fatalf("got %d succeeded and %d denied withdrawals, want 10 and %d", succeeded, denied, attempts-10)
+	}
+
+	got, err := wr.GetByID(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Balance.IsZero() {
+		t.Fatalf("got balance %s, want 0", got.Balance)
+	}
+
+	mutations, err := wr.ListMutation(ctx, types.MutationFilter{WalletID: wallet.ID, Limit: types.MaxMutationListLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mutations) != succeeded {
+		t.Fatalf("got %d mutations, want %d", len(mutations), succeeded)
+	}
+}
+
+func testMutateConcurrentDepositsAreNotLost(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	const attempts = 50
+	amount := types.NewMoney(150, types.DefaultCurrencyExponent)
+
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := wr.Mutate(ctx, newMutation(wallet, types.MutationActionDeposit, amount)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := wr.GetByID(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := types.NewMoney(attempts*150, types.DefaultCurrencyExponent); got.Balance.Cmp(want) != 0 {
+		t.Fatalf("got balance %s, want %s", got.Balance, want)
+	}
+}
+
+func testMutateRejectsInactiveWallet(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(10000, types.DefaultCurrencyExponent))
+	if _, err := wr.Disable(ctx, wallet.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	amount := types.NewMoney(100, types.DefaultCurrencyExponent)
+	_, err := wr.Mutate(ctx, newMutation(wallet, types.MutationActionDeposit, amount))
+	if err != types.ErrWalletInactive {
+		t.Fatalf("got error %v, want %v", err, types.ErrWalletInactive)
+	}
+}
+
+func testAddWalletBalanceByIDRejectsOverflowingBalance(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	max := types.NewMoney(math.MaxInt64, types.DefaultCurrencyExponent)
+	wallet := createActiveWallet(t, wr, types.NewMoney(math.MaxInt64-100, types.DefaultCurrencyExponent))
+
+	if _, err := wr.AddWalletBalanceByID(ctx, types.NewMoney(100, types.DefaultCurrencyExponent), wallet.ID); err != nil {
+		t.Fatalf("credit up to the largest balance: %v", err)
+	}
+
+	_, err := wr.AddWalletBalanceByID(ctx, types.NewMoney(1, types.DefaultCurrencyExponent), wallet.ID)
+	if err != types.ErrBalanceOverflow {
+		t.Fatalf("got error %v, want %v", err, types.ErrBalanceOverflow)
+	}
+
+	got, err := wr.GetByID(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Balance.Cmp(max) != 0 {
+		t.Fatalf("got balance %s, want %s", got.Balance, max)
+	}
+}
+
+func testWithinTransactionRollsBackEveryWrite(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(10000, types.DefaultCurrencyExponent))
+
+	errAbort := errors.New("abort")
+	amount := types.NewMoney(2500, types.DefaultCurrencyExponent)
+	err := wr.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		if _, err := repo.AddWalletBalanceByID(ctx, amount, wallet.ID); err != nil {
+			return err
+		}
+		if err := repo.CreateMutation(ctx, newMutation(wallet, types.MutationActionDeposit, amount)); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("got error %v, want %v", err, errAbort)
+	}
+
+	got, err := wr.GetByID(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Balance.Cmp(wallet.Balance) != 0 {
+		t.Fatalf("got balance %s, want %s", got.Balance, wallet.Balance)
+	}
+
+	mutations, err := wr.ListMutation(ctx, types.MutationFilter{WalletID: wallet.ID, Limit: types.MaxMutationListLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mutations) != 0 {
+		t.Fatalf("got %d mutations, want 0", len(mutations))
+	}
+}
+
+func testAddReversedAmountNeverExceedsMutationAmount(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	deposit := newMutation(wallet, types.MutationActionDeposit, types.NewMoney(10000, types.DefaultCurrencyExponent))
+	if _, err := wr.Mutate(ctx, deposit); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wr.AddReversedAmount(ctx, deposit.ID, types.NewMoney(6000, types.DefaultCurrencyExponent)); err != nil {
+		t.Fatal(err)
+	}
+	err := wr.AddReversedAmount(ctx, deposit.ID, types.NewMoney(4001, types.DefaultCurrencyExponent))
+	if err != types.ErrReversalExceedsAmount {
+		t.Fatalf("got error %v, want %v", err, types.ErrReversalExceedsAmount)
+	}
+
+	got, err := wr.GetMutationByID(ctx, deposit.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := types.NewMoney(4000, types.DefaultCurrencyExponent); got.ReversibleAmount().Cmp(want) != 0 {
+		t.Fatalf("got reversible amount %s, want %s", got.ReversibleAmount(), want)
+	}
+}
+
+func testListMutationPagesWithoutGapsOrRepeats(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	// Mutations sharing a timestamp must still page in a stable order.
+	createdAt := time.Now()
+	const total = 7
+	for i := 0; i < total; i++ {
+		mutation := newMutation(wallet, types.MutationActionDeposit, types.NewMoney(100, types.DefaultCurrencyExponent))
+		mutation.CreatedAt = createdAt
+		if _, err := wr.Mutate(ctx, mutation); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ascending := range []bool{false, true} {
+		seen := map[string]bool{}
+		filter := types.MutationFilter{WalletID: wallet.ID, Ascending: ascending, Limit: 3}
+		for {
+			page, err := wr.ListMutation(ctx, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+
+			for _, mutation := range page {
+				if seen[mutation.ID] {
+					t.Fatalf("mutation %s returned twice", mutation.ID)
+				}
+				seen[mutation.ID] = true
+			}
+			filter.AfterID = page[len(page)-1].ID
+		}
+
+		if len(seen) != total {
+			t.Fatalf("got %d mutations with ascending=%v, want %d", len(seen), ascending, total)
+		}
+	}
+}
+
+func testCreateMutationRejectsDuplicateReferenceID(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	first := newMutation(wallet, types.MutationActionDeposit, types.NewMoney(100, types.DefaultCurrencyExponent))
+	if _, err := wr.Mutate(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newMutation(wallet, types.MutationActionDeposit, types.NewMoney(100, types.DefaultCurrencyExponent))
+	second.ReferenceID = first.ReferenceID
+	if _, err := wr.Mutate(ctx, second); !errors.Is(err, types.ErrDuplicateReference) {
+		t.Fatalf("mutation with a duplicate reference_id: got %v, want %v", err, types.ErrDuplicateReference)
+	}
+	if err := wr.CreateMutation(ctx, second); !errors.Is(err, types.ErrDuplicateReference) {
+		t.Fatalf("failed mutation with a duplicate reference_id: got %v, want %v", err, types.ErrDuplicateReference)
+	}
+
+	got, err := wr.GetByID(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := types.NewMoney(100, types.DefaultCurrencyExponent); got.Balance.Cmp(want) != 0 {
+		t.Fatalf("got balance %s, want %s", got.Balance, want)
+	}
+}
+
+// Retries of one request race past the reference ID lookup when none of
+// them has committed yet; the one that stores it wins and the others must
+// replay it rather than fail on the unique reference_id.
+func testConcurrentRetriesOfADepositApplyItOnce(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	wallet := createActiveWallet(t, wr, types.NewMoney(0, types.DefaultCurrencyExponent))
+	ws := service.NewWalletService(wr, types.DefaultTokenLength)
+
+	const attempts = 20
+	req := types.DepositRequest{
+		Auth:        types.Auth{Wallet: wallet},
+		ReferenceID: uuid.NewString(),
+		Amount:      types.NewMoney(150, types.DefaultCurrencyExponent),
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		applied int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := ws.Deposit(ctx, req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !res.Replayed {
+				mu.Lock()
+				applied++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if applied != 1 {
+		t.Errorf("%d of %d retries applied the deposit, want 1", applied, attempts)
+	}
+
+	got, err := wr.GetByID(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Balance.Cmp(req.Amount) != 0 {
+		t.Fatalf("got balance %s, want %s", got.Balance, req.Amount)
+	}
+}
+
+// Transfers between two wallets in opposite directions lock both wallets,
+// and must lock them in the same order so that neither waits on the other.
+func testConcurrentOppositeTransfersDoNotDeadlock(t *testing.T, wr types.WalletRepository) {
+	ctx := context.Background()
+	balance := types.NewMoney(10000, types.DefaultCurrencyExponent)
+	alice := createActiveWallet(t, wr, balance)
+	bob := createActiveWallet(t, wr, balance)
+	ws := service.NewWalletService(wr, types.DefaultTokenLength)
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		for _, pair := range [][2]types.Wallet{{alice, bob}, {bob, alice}} {
+			from, to := pair[0], pair[1]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := ws.Transfer(ctx, types.TransferRequest{
+					Auth:        types.Auth{Wallet: from},
+					ToWalletID:  to.ID,
+					ReferenceID: uuid.NewString(),
+					Amount:      types.NewMoney(100, types.DefaultCurrencyExponent),
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, wallet := range []types.Wallet{alice, bob} {
+		got, err := wr.GetByID(ctx, wallet.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Balance.Cmp(balance) != 0 {
+			t.Errorf("got balance %s, want %s", got.Balance, balance)
+		}
+	}
+}
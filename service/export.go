@@ -0,0 +1,211 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+// exportPageSize is how many mutations are read per query while exporting.
+// Reading in pages keeps memory flat and avoids holding a read open on the
+// database for the whole download.
+const exportPageSize = 500
+
+// mutationEncoder writes a statement in one export format.
+type mutationEncoder interface {
+	Begin(wallet types.Wallet, req types.MutationExportRequest) error
+	Encode(types.Mutation) error
+	End() error
+}
+
+// ExportMutation streams the wallet's mutations, oldest first, in the
+// requested format.
+func (ws *walletService) ExportMutation(ctx context.Context, req types.MutationExportRequest, w io.Writer) error {
+	enc, err := newMutationEncoder(req.Format, w)
+	if err != nil {
+		return err
+	}
+
+	wallet := req.Auth.Wallet
+
+	if err = enc.Begin(wallet, req); err != nil {
+		return err
+	}
+
+	filter := types.MutationFilter{
+		WalletID:  wallet.ID,
+		From:      req.From,
+		To:        req.To,
+		Ascending: true,
+		Limit:     exportPageSize,
+	}
+	for {
+		page, err := ws.walletRepo.ListMutation(ctx, filter)
+		if err != nil {
+			log.Println("walletService.ExportMutation", err)
+			return err
+		}
+
+		for _, mutation := range page {
+			if err = enc.Encode(mutation); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPageSize {
+			break
+		}
+		filter.AfterID = page[len(page)-1].ID
+	}
+
+	return enc.End()
+}
+
+// helpers
+
+func newMutationEncoder(format types.MutationExportFormat, w io.Writer) (mutationEncoder, error) {
+	switch format {
+	case types.MutationExportFormatCSV:
+		return &csvMutationEncoder{w: csv.NewWriter(w)}, nil
+	case types.MutationExportFormatJSONLines:
+		bw := bufio.NewWriter(w)
+		return &jsonLinesMutationEncoder{w: bw, enc: json.NewEncoder(bw)}, nil
+	case types.MutationExportFormatOFX:
+		return &ofxMutationEncoder{w: bufio.NewWriter(w)}, nil
+	}
+	return nil, types.ErrUnknownExportFormat
+}
+
+type csvMutationEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvMutationEncoder) Begin(types.Wallet, types.MutationExportRequest) error {
+	return e.w.Write([]string{
+		"id", "created_at", "action", "status", "amount", "reference_id",
+		"counterparty_wallet_id", "related_mutation_id", "failure_reason", "fx_rate",
+	})
+}
+
+func (e *csvMutationEncoder) Encode(m types.Mutation) error {
+	r := types.NewMutationExportRecord(m)
+	return e.w.Write([]string{
+		r.ID, r.CreatedAt.UTC().Format(time.RFC3339Nano), r.Action, r.Status, r.Amount.String(), r.ReferenceID,
+		r.CounterpartyWalletID, r.RelatedID, r.FailureReason, r.FXRate,
+	})
+}
+
+func (e *csvMutationEncoder) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonLinesMutationEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *jsonLinesMutationEncoder) Begin(types.Wallet, types.MutationExportRequest) error {
+	return nil
+}
+
+func (e *jsonLinesMutationEncoder) Encode(m types.Mutation) error {
+	r := types.NewMutationExportRecord(m)
+	r.CreatedAt = r.CreatedAt.UTC()
+	return e.enc.Encode(r)
+}
+
+func (e *jsonLinesMutationEncoder) End() error {
+	return e.w.Flush()
+}
+
+// ofxMutationEncoder writes an OFX 2.2 bank statement. Only successful
+// mutations are statement transactions; failed ones are left out.
+type ofxMutationEncoder struct {
+	w      *bufio.Writer
+	wallet types.Wallet
+}
+
+const ofxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>WALLET</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>
+`
+
+const ofxTransaction = `<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%s</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>
+`
+
+const ofxFooter = `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+<AVAILBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></AVAILBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+func (e *ofxMutationEncoder) Begin(wallet types.Wallet, req types.MutationExportRequest) error {
+	e.wallet = wallet
+
+	now := time.Now()
+	end := req.To
+	if end.IsZero() {
+		end = now
+	}
+	_, err := fmt.Fprintf(e.w, ofxHeader,
+		ofxTime(now), wallet.Currency, ofxEscape(wallet.ID), ofxTime(req.From), ofxTime(end))
+	return err
+}
+
+func (e *ofxMutationEncoder) Encode(m types.Mutation) error {
+	if m.Status != int(types.MutationStatusSuccess) {
+		return nil
+	}
+
+	amount := m.BalanceDelta()
+	trnType := "CREDIT"
+	if amount.Minor < 0 {
+		trnType = "DEBIT"
+	}
+	if m.Counterparty != "" {
+		trnType = "XFER"
+	}
+
+	_, err := fmt.Fprintf(e.w, ofxTransaction,
+		trnType, ofxTime(m.CreatedAt), amount, ofxEscape(m.ID), ofxEscape(m.GetActionString()), ofxEscape(m.ReferenceID))
+	return err
+}
+
+func (e *ofxMutationEncoder) End() error {
+	now := ofxTime(time.Now())
+	if _, err := fmt.Fprintf(e.w, ofxFooter, e.wallet.Balance, now, e.wallet.AvailableBalance(), now); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// ofxTime formats t as an OFX date-time in UTC. The zero time is the
+// start of the Unix epoch.
+func ofxTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format("20060102150405") + "[0:GMT]"
+}
+
+func ofxEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
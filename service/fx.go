@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+type fxService struct {
+	fxRepo types.FXRepository
+}
+
+func NewFXService(fr types.FXRepository) types.FXService {
+	return &fxService{
+		fxRepo: fr,
+	}
+}
+
+// SetRate adds a rate to the table. Earlier rates are kept, so quotes
+// and conversions made before EffectiveAt still refer to the rate they
+// used.
+func (fs *fxService) SetRate(ctx context.Context, req types.SetFXRateRequest) (types.FXRateResponse, error) {
+	// Unlike for wallets, there is no default currency for a rate.
+	if req.Base == "" || req.Quote == "" {
+		return types.FXRateResponse{}, types.ErrUnsupportedCurrency
+	}
+	base, err := types.NormalizeCurrency(req.Base)
+	if err != nil {
+		return types.FXRateResponse{}, err
+	}
+	quote, err := types.NormalizeCurrency(req.Quote)
+	if err != nil {
+		return types.FXRateResponse{}, err
+	}
+	if base == quote || req.Rate.IsZero() {
+		return types.FXRateResponse{}, types.ErrInvalidRate
+	}
+
+	now := time.Now()
+	rate := types.FXRate{
+		Base:        base,
+		Quote:       quote,
+		Rate:        req.Rate,
+		EffectiveAt: req.EffectiveAt,
+		CreatedAt:   now,
+	}
+	if rate.EffectiveAt.IsZero() {
+		rate.EffectiveAt = now
+	}
+
+	if err = fs.fxRepo.CreateRate(ctx, rate); err != nil {
+		log.Println("fxService.SetRate", err)
+		return types.FXRateResponse{}, err
+	}
+
+	return types.FXRateResponse{
+		Base:        rate.Base,
+		Quote:       rate.Quote,
+		Rate:        rate.Rate,
+		EffectiveAt: rate.EffectiveAt,
+	}, nil
+}
+
+// LoadRates stores every rate of a CSV file. An optional header row is
+// skipped, and an empty effective_at means now. Loading stops at the first
+// invalid line; the lines before it are kept.
+func (fs *fxService) LoadRates(ctx context.Context, r io.Reader) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 4
+	cr.TrimLeadingSpace = true
+
+	loaded := 0
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if line == 1 && strings.EqualFold(record[0], "base") {
+			continue
+		}
+
+		req := types.SetFXRateRequest{
+			Base:  record[0],
+			Quote: record[1],
+		}
+		if req.Rate, err = types.ParseRate(record[2]); err != nil {
+			return loaded, fmt.Errorf("line %d: %w", line, err)
+		}
+		if record[3] != "" {
+			if req.EffectiveAt, err = time.Parse(time.RFC3339, record[3]); err != nil {
+				return loaded, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+		if _, err = fs.SetRate(ctx, req); err != nil {
+			return loaded, fmt.Errorf("line %d: %w", line, err)
+		}
+		loaded++
+	}
+}
+
+// CreateFXQuote prices converting an amount from the caller's wallet to
+// another wallet of theirs at the current rate, and holds that rate for
+// DefaultFXQuoteDuration.
+func (ws *walletService) CreateFXQuote(ctx context.Context, req types.CreateFXQuoteRequest) (types.FXQuoteResponse, error) {
+	wallet := req.Auth.Wallet
+
+	target, err := ws.getConversionTarget(ctx, wallet, req.ToWalletID)
+	if err != nil {
+		return types.FXQuoteResponse{}, err
+	}
+
+	amount, err := walletAmount(wallet, req.Amount, "")
+	if err != nil {
+		return types.FXQuoteResponse{}, err
+	}
+
+	now := time.Now()
+	rate, err := ws.walletRepo.FX().GetRate(ctx, wallet.Currency, target.Currency, now)
+	if err != nil {
+		log.Println("walletService.CreateFXQuote.GetRate", err)
+		return types.FXQuoteResponse{}, err
+	}
+
+	converted, err := rate.Rate.Convert(amount, types.CurrencyExponent(target.Currency))
+	if err != nil {
+		return types.FXQuoteResponse{}, err
+	}
+	if !converted.IsPositive() {
+		return types.FXQuoteResponse{}, types.ErrConversionTooSmall
+	}
+
+	quote := types.FXQuote{
+		ID:              uuid.NewString(),
+		WalletID:        wallet.ID,
+		ToWalletID:      target.ID,
+		FromCurrency:    wallet.Currency,
+		ToCurrency:      target.Currency,
+		Rate:            rate.Rate,
+		Amount:          amount,
+		ConvertedAmount: converted,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(types.DefaultFXQuoteDuration).UTC(),
+	}
+	if err = ws.walletRepo.FX().CreateQuote(ctx, quote); err != nil {
+		log.Println("walletService.CreateFXQuote", err)
+		return types.FXQuoteResponse{}, err
+	}
+
+	return makeFXQuoteResponse(quote), nil
+}
+
+// ConvertFX carries out a quote: the quoted amount is debited from the
+// caller's wallet and the converted amount credited to the target wallet
+// in one transaction. Both mutations record the quoted rate.
+func (ws *walletService) ConvertFX(ctx context.Context, req types.ConvertFXRequest) (types.ConversionResponse, error) {
+	wallet := req.Auth.Wallet
+
+	quote, err := ws.walletRepo.FX().GetQuote(ctx, req.QuoteID)
+	if err != nil {
+		return types.ConversionResponse{}, err
+	}
+	if quote.WalletID != wallet.ID {
+		return types.ConversionResponse{}, types.ErrQuoteNotFound
+	}
+
+	target, err := ws.walletRepo.GetByID(ctx, quote.ToWalletID)
+	if err != nil {
+		log.Println("walletService.ConvertFX.GetByID", err)
+		return types.ConversionResponse{}, err
+	}
+
+	now := time.Now()
+	outgoing := types.Mutation{
+		ID:           uuid.NewString(),
+		ReferenceID:  req.ReferenceID,
+		CreatedAt:    now,
+		CreatedBy:    wallet.OwnedBy,
+		WalletID:     wallet.ID,
+		Action:       int(types.MutationActionConvertOut),
+		Status:       int(types.MutationStatusSuccess),
+		Amount:       quote.Amount,
+		Currency:     quote.FromCurrency,
+		Counterparty: target.ID,
+		FXRate:       quote.Rate.String(),
+		QuoteID:      quote.ID,
+	}
+	// As with transfers, the incoming side is keyed by the outgoing
+	// mutation ID.
+	incoming := types.Mutation{
+		ID:           uuid.NewString(),
+		ReferenceID:  outgoing.ID,
+		CreatedAt:    now,
+		CreatedBy:    target.OwnedBy,
+		WalletID:     target.ID,
+		Action:       int(types.MutationActionConvertIn),
+		Status:       int(types.MutationStatusSuccess),
+		Amount:       quote.ConvertedAmount,
+		Currency:     quote.ToCurrency,
+		RelatedID:    outgoing.ID,
+		Counterparty: wallet.ID,
+		FXRate:       outgoing.FXRate,
+		QuoteID:      quote.ID,
+	}
+	outgoing.RelatedID = incoming.ID
+
+	mutation, replayed, err := ws.applyMutation(ctx, wallet, outgoing, func(repo types.WalletRepository) error {
+		current, err := repo.FX().GetQuote(ctx, quote.ID)
+		if err != nil {
+			return err
+		}
+		if current.UsedAt.Valid {
+			return types.ErrQuoteUsed
+		}
+		if current.IsExpired(now) {
+			return types.ErrQuoteExpired
+		}
+
+		recipient, err := repo.GetByID(ctx, target.ID)
+		if err != nil {
+			return err
+		}
+		if recipient.Status != int(types.StatusActive) {
+			return types.ErrRecipientInactive
+		}
+
+		if _, err = repo.Mutate(ctx, outgoing); err != nil {
+			return err
+		}
+
+		if _, err = repo.Mutate(ctx, incoming); err != nil {
+			return err
+		}
+
+		return repo.FX().UseQuote(ctx, quote.ID, now)
+	})
+	if err != nil {
+		log.Println("walletService.ConvertFX", err)
+		return types.ConversionResponse{}, err
+	}
+
+	res := makeConversionResponse(mutation)
+	res.Replayed = replayed
+	return res, nil
+}
+
+// helpers
+
+// getConversionTarget finds the wallet a conversion credits, which must be
+// another wallet of the same customer in a different currency.
+func (ws *walletService) getConversionTarget(ctx context.Context, wallet types.Wallet, targetID string) (types.Wallet, error) {
+	target, err := ws.walletRepo.GetByID(ctx, targetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Wallet{}, types.ErrRecipientNotFound
+	}
+	if err != nil {
+		return types.Wallet{}, err
+	}
+
+	switch {
+	case target.ID == wallet.ID:
+		return types.Wallet{}, types.ErrSameWallet
+	case target.OwnedBy != wallet.OwnedBy:
+		return types.Wallet{}, types.ErrConversionTarget
+	case target.Currency == wallet.Currency:
+		return types.Wallet{}, types.ErrSameCurrency
+	}
+
+	return target, nil
+}
+
+func makeFXQuoteResponse(quote types.FXQuote) types.FXQuoteResponse {
+	return types.FXQuoteResponse{
+		ID:              quote.ID,
+		WalletID:        quote.WalletID,
+		ToWalletID:      quote.ToWalletID,
+		FromCurrency:    quote.FromCurrency,
+		ToCurrency:      quote.ToCurrency,
+		Rate:            quote.Rate,
+		Amount:          quote.Amount,
+		ConvertedAmount: quote.ConvertedAmount,
+		ExpiresAt:       quote.ExpiresAt,
+	}
+}
+
+func makeConversionResponse(mutation types.Mutation) types.ConversionResponse {
+	return types.ConversionResponse{
+		ID:            mutation.ID,
+		OwnedBy:       mutation.CreatedBy,
+		Direction:     mutation.GetTransferDirectionString(),
+		Counterparty:  mutation.Counterparty,
+		Status:        mutation.GetStatusString(),
+		ConvertedAt:   mutation.CreatedAt,
+		Amount:        mutation.Amount,
+		Currency:      mutation.Currency,
+		FXRate:        mutation.FXRate,
+		ReferenceID:   mutation.ReferenceID,
+		FailureReason: mutation.FailureReason,
+	}
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+// CreateHold reserves funds on the wallet. The balance is unchanged but
+// the available balance drops by the held amount.
+func (ws *walletService) CreateHold(ctx context.Context, req types.CreateHoldRequest) (types.HoldResponse, error) {
+	wallet := req.Auth.Wallet
+
+	if wallet.Status != int(types.StatusActive) {
+		return types.HoldResponse{}, types.ErrWalletInactive
+	}
+
+	amount, err := walletAmount(wallet, req.Amount, req.Currency)
+	if err != nil {
+		return types.HoldResponse{}, err
+	}
+
+	duration := types.DefaultHoldDuration
+	if req.ExpiresIn > 0 {
+		duration = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	now := time.Now()
+	hold := types.Hold{
+		ID:             uuid.NewString(),
+		WalletID:       wallet.ID,
+		ReferenceID:    req.ReferenceID,
+		Status:         int(types.HoldStatusActive),
+		Amount:         amount,
+		CapturedAmount: types.NewMoney(0, amount.Exponent),
+		Currency:       wallet.Currency,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(duration).UTC(),
+	}
+
+	replayed := false
+	err = ws.walletRepo.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		var err error
+		if hold, replayed, err = findHoldReplay(ctx, repo, hold); err != nil || replayed {
+			return err
+		}
+
+		if err = expireHolds(ctx, repo, wallet.ID); err != nil {
+			return err
+		}
+
+		return repo.CreateHold(ctx, hold)
+	})
+	// A concurrent request may have stored the reference ID since, see
+	// findConcurrentReplay.
+	if errors.Is(err, types.ErrDuplicateReference) {
+		if existing, ok, findErr := findHoldReplay(ctx, ws.walletRepo, hold); findErr != nil || ok {
+			hold, replayed, err = existing, ok, findErr
+		}
+	}
+	if err != nil {
+		log.Println("walletService.CreateHold", err)
+		return types.HoldResponse{}, err
+	}
+
+	res := makeHoldResponse(hold)
+	res.Replayed = replayed
+	return res, nil
+}
+
+// CaptureHold turns all or part of an active hold into a withdrawal. The
+// hold is finished either way; any uncaptured remainder becomes available
+// again.
+func (ws *walletService) CaptureHold(ctx context.Context, req types.CaptureHoldRequest) (types.CaptureHoldResponse, error) {
+	wallet, hold, err := ws.getWalletHold(ctx, req.Auth.Wallet, req.HoldID)
+	if err != nil {
+		log.Println("walletService.CaptureHold", err)
+		return types.CaptureHoldResponse{}, err
+	}
+
+	amount := hold.Amount
+	if !req.Amount.IsZero() {
+		amount, err = walletAmount(wallet, req.Amount, "")
+		if err != nil {
+			return types.CaptureHoldResponse{}, err
+		}
+	}
+	if amount.Cmp(hold.Amount) > 0 {
+		return types.CaptureHoldResponse{}, types.ErrCaptureExceedsHold
+	}
+
+	mutation := types.Mutation{
+		ID:          uuid.NewString(),
+		ReferenceID: req.ReferenceID,
+		CreatedAt:   time.Now(),
+		CreatedBy:   wallet.OwnedBy,
+		WalletID:    wallet.ID,
+		Action:      int(types.MutationActionWithdraw),
+		Status:      int(types.MutationStatusSuccess),
+		Amount:      amount,
+		Currency:    wallet.Currency,
+	}
+
+	mutation, replayed, err := ws.applyMutation(ctx, wallet, mutation, func(repo types.WalletRepository) error {
+		current, err := repo.GetHold(ctx, hold.ID)
+		if err != nil {
+			return err
+		}
+		if current.IsExpired(time.Now()) {
+			return types.ErrHoldNotActive
+		}
+
+		// The wallet may have been disabled since the request was
+		// authenticated; the hold must then stay as it is.
+		owner, err := repo.GetByID(ctx, wallet.ID)
+		if err != nil {
+			return err
+		}
+		if owner.Status != int(types.StatusActive) {
+			return types.ErrWalletInactive
+		}
+
+		// Releasing first returns the held funds to the available balance
+		// so the withdrawal below can spend them.
+		hold, err = repo.ReleaseHold(ctx, hold.ID, types.HoldStatusCaptured, amount, mutation.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = repo.Mutate(ctx, mutation)
+		return err
+	})
+	if err != nil {
+		log.Println("walletService.CaptureHold", err)
+		return types.CaptureHoldResponse{}, err
+	}
+
+	if replayed {
+		if hold, err = ws.walletRepo.GetHold(ctx, hold.ID); err != nil {
+			return types.CaptureHoldResponse{}, err
+		}
+		if hold.MutationID != mutation.ID {
+			return types.CaptureHoldResponse{}, types.ErrReferenceConflict
+		}
+	}
+
+	return types.CaptureHoldResponse{
+		Hold: makeHoldResponse(hold),
+		Withdrawal: types.WithdrawResponse{
+			ID:          mutation.ID,
+			WithdrawnBy: mutation.CreatedBy,
+			Status:      mutation.GetStatusString(),
+			WithdrawnAt: mutation.CreatedAt,
+			Amount:      mutation.Amount,
+			Currency:    mutation.Currency,
+			ReferenceID: mutation.ReferenceID,
+			Replayed:    replayed,
+		},
+	}, nil
+}
+
+// VoidHold releases an active hold without moving any money.
+func (ws *walletService) VoidHold(ctx context.Context, req types.VoidHoldRequest) (types.HoldResponse, error) {
+	_, hold, err := ws.getWalletHold(ctx, req.Auth.Wallet, req.HoldID)
+	if err != nil {
+		log.Println("walletService.VoidHold", err)
+		return types.HoldResponse{}, err
+	}
+
+	hold, err = ws.walletRepo.ReleaseHold(ctx, hold.ID, types.HoldStatusVoided, types.NewMoney(0, hold.Amount.Exponent), "")
+	if err != nil {
+		log.Println("walletService.VoidHold.ReleaseHold", err)
+		return types.HoldResponse{}, err
+	}
+
+	return makeHoldResponse(hold), nil
+}
+
+// helpers
+
+// getWalletHold loads one of the caller's holds. Holds of other wallets are
+// reported as not found.
+func (ws *walletService) getWalletHold(ctx context.Context, wallet types.Wallet, holdID string) (types.Wallet, types.Hold, error) {
+	hold, err := ws.walletRepo.GetHold(ctx, holdID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && hold.WalletID != wallet.ID) {
+		return types.Wallet{}, types.Hold{}, types.ErrHoldNotFound
+	}
+
+	return wallet, hold, err
+}
+
+// expireHolds releases the wallet's active holds that are past their
+// expiry time. Holds are expired lazily, whenever the wallet's available
+// balance matters.
+func expireHolds(ctx context.Context, repo types.WalletRepository, walletID string) error {
+	holds, err := repo.ListActiveHolds(ctx, walletID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, hold := range holds {
+		if !hold.IsExpired(now) {
+			continue
+		}
+
+		_, err = repo.ReleaseHold(ctx, hold.ID, types.HoldStatusExpired, types.NewMoney(0, hold.Amount.Exponent), "")
+		if err != nil && err != types.ErrHoldNotActive {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findHoldReplay looks up the hold already stored under the reference ID
+// of hold, like findReplay does for mutations.
+func findHoldReplay(ctx context.Context, repo types.WalletRepository, hold types.Hold) (types.Hold, bool, error) {
+	existing, err := repo.GetHoldByReferenceID(ctx, hold.ReferenceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return hold, false, nil
+	}
+	if err != nil {
+		return hold, false, err
+	}
+
+	if existing.WalletID != hold.WalletID || existing.Amount.Cmp(hold.Amount) != 0 {
+		return hold, false, types.ErrReferenceConflict
+	}
+	return existing, true, nil
+}
+
+func makeHoldResponse(hold types.Hold) types.HoldResponse {
+	return types.HoldResponse{
+		ID:             hold.ID,
+		WalletID:       hold.WalletID,
+		Status:         hold.GetStatusString(),
+		Amount:         hold.Amount,
+		CapturedAmount: hold.CapturedAmount,
+		Currency:       hold.Currency,
+		ReferenceID:    hold.ReferenceID,
+		CreatedAt:      hold.CreatedAt,
+		ExpiresAt:      hold.ExpiresAt,
+	}
+}
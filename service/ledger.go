@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+type ledgerService struct {
+	ledgerRepo types.LedgerRepository
+}
+
+func NewLedgerService(lr types.LedgerRepository) types.LedgerService {
+	return &ledgerService{
+		ledgerRepo: lr,
+	}
+}
+
+// TrialBalance lists the balance of every ledger account. Because every
+// journal entry sums to zero, so must the accounts; Balanced reports
+// whether they do.
+func (ls *ledgerService) TrialBalance(ctx context.Context) (types.TrialBalanceResponse, error) {
+	balances, err := ls.ledgerRepo.ListAccountBalances(ctx)
+	if err != nil {
+		log.Println("ledgerService.TrialBalance", err)
+		return types.TrialBalanceResponse{}, err
+	}
+
+	res := types.TrialBalanceResponse{
+		Accounts: []types.AccountBalanceResponse{},
+		Totals:   []types.CurrencyTotalResponse{},
+		Balanced: true,
+	}
+	totals := map[string]types.Money{}
+	for _, balance := range balances {
+		res.Accounts = append(res.Accounts, types.AccountBalanceResponse{
+			AccountID: balance.AccountID,
+			Name:      balance.Name,
+			Balance:   balance.Balance,
+			Currency:  balance.Currency,
+		})
+		total, err := totals[balance.Currency].Add(balance.Balance)
+		if err != nil {
+			log.Println("ledgerService.TrialBalance", err)
+			return types.TrialBalanceResponse{}, err
+		}
+		totals[balance.Currency] = total
+	}
+
+	// Currencies cannot be added together, so each must balance by itself.
+	currencies := make([]string, 0, len(totals))
+	for currency := range totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		res.Totals = append(res.Totals, types.CurrencyTotalResponse{
+			Currency: currency,
+			Total:    totals[currency],
+		})
+		if !totals[currency].IsZero() {
+			res.Balanced = false
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+// ReverseMutation refunds all or part of a successful deposit or withdrawal
+// with a compensating mutation linked to it. A mutation can be reversed in
+// several parts but never by more than its amount.
+func (ws *walletService) ReverseMutation(ctx context.Context, req types.ReverseMutationRequest) (types.ReversalResponse, error) {
+	original, err := ws.walletRepo.GetMutationByID(ctx, req.MutationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.ReversalResponse{}, types.ErrMutationNotFound
+	}
+	if err != nil {
+		log.Println("walletService.ReverseMutation.GetMutationByID", err)
+		return types.ReversalResponse{}, err
+	}
+
+	action, ok := original.ReversalAction()
+	if !ok || original.Status != int(types.MutationStatusSuccess) {
+		return types.ReversalResponse{}, types.ErrMutationNotReversible
+	}
+
+	wallet, err := ws.walletRepo.GetByID(ctx, original.WalletID)
+	if err != nil {
+		log.Println("walletService.ReverseMutation.GetByID", err)
+		return types.ReversalResponse{}, err
+	}
+
+	amount, err := ws.reversalAmount(ctx, wallet, original, req)
+	if err != nil {
+		return types.ReversalResponse{}, err
+	}
+	reversal := types.Mutation{
+		ID:          uuid.NewString(),
+		ReferenceID: req.ReferenceID,
+		CreatedAt:   time.Now(),
+		CreatedBy:   wallet.OwnedBy,
+		WalletID:    wallet.ID,
+		Action:      int(action),
+		Status:      int(types.MutationStatusSuccess),
+		Amount:      amount,
+		Currency:    wallet.Currency,
+		RelatedID:   original.ID,
+	}
+
+	mutation, replayed, err := ws.applyMutation(ctx, wallet, reversal, func(repo types.WalletRepository) error {
+		// Re-read the original so that a concurrent reversal is seen
+		// before the wallet is touched.
+		current, err := repo.GetMutationByID(ctx, original.ID)
+		if err != nil {
+			return err
+		}
+		if !amount.IsPositive() || amount.Cmp(current.ReversibleAmount()) > 0 {
+			return types.ErrReversalExceedsAmount
+		}
+
+		if _, err := repo.Mutate(ctx, reversal); err != nil {
+			return err
+		}
+
+		return repo.AddReversedAmount(ctx, original.ID, amount)
+	})
+	if err != nil {
+		log.Println("walletService.ReverseMutation", err)
+		return types.ReversalResponse{}, err
+	}
+
+	if replayed && mutation.RelatedID != original.ID {
+		return types.ReversalResponse{}, types.ErrReferenceConflict
+	}
+
+	res := makeReversalResponse(mutation)
+	res.Replayed = replayed
+	return res, nil
+}
+
+// helpers
+
+// reversalAmount returns the amount req asks to reverse. When it is omitted
+// that is everything not yet reversed, unless req retries a reversal of
+// original already stored, which has reversed that amount itself.
+func (ws *walletService) reversalAmount(ctx context.Context, wallet types.Wallet, original types.Mutation, req types.ReverseMutationRequest) (types.Money, error) {
+	if !req.Amount.IsZero() {
+		return walletAmount(wallet, req.Amount, "")
+	}
+
+	existing, err := ws.walletRepo.GetMutationByReferenceID(ctx, req.ReferenceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return original.ReversibleAmount(), nil
+	}
+	if err != nil {
+		log.Println("walletService.ReverseMutation.GetMutationByReferenceID", err)
+		return types.Money{}, err
+	}
+	if existing.RelatedID != original.ID {
+		return types.Money{}, types.ErrReferenceConflict
+	}
+	return existing.Amount, nil
+}
+
+func makeReversalResponse(mutation types.Mutation) types.ReversalResponse {
+	return types.ReversalResponse{
+		ID:            mutation.ID,
+		OwnedBy:       mutation.CreatedBy,
+		ReversedID:    mutation.RelatedID,
+		Status:        mutation.GetStatusString(),
+		ReversedAt:    mutation.CreatedAt,
+		Amount:        mutation.Amount,
+		Currency:      mutation.Currency,
+		ReferenceID:   mutation.ReferenceID,
+		FailureReason: mutation.FailureReason,
+	}
+}
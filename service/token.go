@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func (ws *walletService) Authenticate(ctx context.Context, token string) (types.Auth, error) {
+	current, err := ws.walletRepo.GetToken(ctx, token)
+	if err != nil {
+		return types.Auth{}, err
+	}
+
+	wallet, err := ws.walletRepo.GetByID(ctx, current.WalletID)
+	if err != nil {
+		log.Println("walletService.Authenticate", err)
+		return types.Auth{}, err
+	}
+
+	return types.Auth{Token: current, Wallet: wallet}, nil
+}
+
+// ListTokens lists every token of the caller's wallet, including expired
+// and revoked ones, without the token values.
+func (ws *walletService) ListTokens(ctx context.Context, req types.ListTokensRequest) (types.TokenListResponse, error) {
+	current := req.Auth.Token
+
+	tokens, err := ws.walletRepo.ListTokens(ctx, current.WalletID)
+	if err != nil {
+		log.Println("walletService.ListTokens", err)
+		return types.TokenListResponse{}, err
+	}
+
+	res := types.TokenListResponse{Tokens: []types.TokenResponse{}}
+	for _, token := range tokens {
+		res.Tokens = append(res.Tokens, makeTokenResponse(token, current.ID))
+	}
+
+	return res, nil
+}
+
+// CreateToken adds a token to the caller's wallet. The caller's token
+// keeps working.
+func (ws *walletService) CreateToken(ctx context.Context, req types.CreateTokenRequest) (types.TokenResponse, error) {
+	current := req.Auth.Token
+	if current.ExpiresAt.Valid {
+		return types.TokenResponse{}, types.ErrTokenExpiring
+	}
+
+	scopes := current.Scopes
+	if len(req.Scopes) > 0 {
+		parsed, err := types.ParseScopes(req.Scopes)
+		if err != nil {
+			return types.TokenResponse{}, err
+		}
+		scopes = parsed
+	}
+
+	token, value, err := newWalletToken(current.WalletID, scopes, ws.tokenLength)
+	if err != nil {
+		return types.TokenResponse{}, err
+	}
+
+	if err = ws.walletRepo.CreateToken(ctx, token); err != nil {
+		log.Println("walletService.CreateToken", err)
+		return types.TokenResponse{}, err
+	}
+
+	res := makeTokenResponse(token, current.ID)
+	res.Token = value
+	return res, nil
+}
+
+func (ws *walletService) RotateToken(ctx context.Context, req types.RotateTokenRequest) (types.TokenResponse, error) {
+	gracePeriod := time.Duration(req.GracePeriod) * time.Second
+	if gracePeriod < 0 || gracePeriod > types.MaxTokenGracePeriod {
+		return types.TokenResponse{}, types.ErrInvalidGracePeriod
+	}
+
+	// A token rotated out could otherwise hand itself a successor that
+	// never expires.
+	current := req.Auth.Token
+	if current.ExpiresAt.Valid {
+		return types.TokenResponse{}, types.ErrTokenExpiring
+	}
+
+	token, value, err := newWalletToken(current.WalletID, current.Scopes, ws.tokenLength)
+	if err != nil {
+		return types.TokenResponse{}, err
+	}
+
+	err = ws.walletRepo.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		if err := repo.CreateToken(ctx, token); err != nil {
+			return err
+		}
+
+		return repo.ExpireToken(ctx, current.ID, token.CreatedAt.Add(gracePeriod))
+	})
+	if err != nil {
+		log.Println("walletService.RotateToken", err)
+		return types.TokenResponse{}, err
+	}
+
+	res := makeTokenResponse(token, current.ID)
+	res.Token = value
+	return res, nil
+}
+
+// RevokeToken invalidates any token of the caller's wallet, including the
+// one the request was made with.
+func (ws *walletService) RevokeToken(ctx context.Context, req types.RevokeTokenRequest) (types.TokenResponse, error) {
+	current := req.Auth.Token
+
+	var revoked types.Token
+	err := ws.walletRepo.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		if err := repo.RevokeToken(ctx, req.TokenID, time.Now()); err != nil {
+			return err
+		}
+
+		tokens, err := repo.ListTokens(ctx, current.WalletID)
+		if err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			if token.ID == req.TokenID {
+				revoked = token
+				return nil
+			}
+		}
+
+		// The token belongs to another wallet; undo the revocation.
+		return types.ErrTokenNotFound
+	})
+	if err != nil {
+		log.Println("walletService.RevokeToken", err)
+		return types.TokenResponse{}, err
+	}
+
+	return makeTokenResponse(revoked, current.ID), nil
+}
+
+// helpers
+
+// newWalletToken generates a token of length random bytes for the wallet
+// and returns it in clear along with the hashed record to store.
+func newWalletToken(walletID string, scopes types.Scopes, length int) (types.Token, string, error) {
+	value, err := makeToken(length)
+	if err != nil {
+		return types.Token{}, "", err
+	}
+
+	hash, err := types.HashToken(value)
+	if err != nil {
+		return types.Token{}, "", err
+	}
+
+	return types.Token{
+		ID:        uuid.NewString(),
+		WalletID:  walletID,
+		Prefix:    types.TokenPrefix(value),
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}, value, nil
+}
+
+func makeTokenResponse(token types.Token, currentID string) types.TokenResponse {
+	res := types.TokenResponse{
+		ID:        token.ID,
+		Prefix:    token.Prefix,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+		Current:   token.ID == currentID,
+	}
+	if token.ExpiresAt.Valid {
+		res.ExpiresAt = &token.ExpiresAt.Time
+	}
+	if token.RevokedAt.Valid {
+		res.RevokedAt = &token.RevokedAt.Time
+	}
+
+	return res
+}
@@ -1,10 +1,15 @@
 package service
 
 import (
+	"context"
 	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,39 +17,57 @@ import (
 )
 
 type walletService struct {
-	walletRepo types.WalletRepository
+	walletRepo  types.WalletRepository
+	tokenLength int
 }
 
-func NewWalletService(wr types.WalletRepository) types.WalletService {
+// NewWalletService returns a wallet service whose new tokens have
+// tokenLength random bytes, see types.DefaultTokenLength.
+func NewWalletService(wr types.WalletRepository, tokenLength int) types.WalletService {
 	return &walletService{
-		walletRepo: wr,
+		walletRepo:  wr,
+		tokenLength: tokenLength,
 	}
 }
 
-func (ws *walletService) Initialize(req types.InitializeRequest) (types.InitializeResponse, error) {
-	newToken, err := makeToken()
+func (ws *walletService) Initialize(ctx context.Context, req types.InitializeRequest) (types.InitializeResponse, error) {
+	currency, err := types.NormalizeCurrency(req.Currency)
 	if err != nil {
 		return types.InitializeResponse{}, err
 	}
 
-	err = ws.walletRepo.Create(types.Wallet{
-		ID:      uuid.NewString(),
-		OwnedBy: req.CustomerID,
-		Token:   newToken,
-		Status:  int(types.StatusNewlyCreated),
-		Balance: 0,
+	wallet := types.Wallet{
+		ID:       uuid.NewString(),
+		OwnedBy:  req.CustomerID,
+		Status:   int(types.StatusNewlyCreated),
+		Balance:  types.NewMoney(0, types.CurrencyExponent(currency)),
+		Currency: currency,
+	}
+	token, value, err := newWalletToken(wallet.ID, types.AllScopes, ws.tokenLength)
+	if err != nil {
+		return types.InitializeResponse{}, err
+	}
+
+	err = ws.walletRepo.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		if err := repo.Create(ctx, wallet); err != nil {
+			return err
+		}
+
+		return repo.CreateToken(ctx, token)
 	})
 	if err != nil {
 		return types.InitializeResponse{}, err
 	}
 
 	return types.InitializeResponse{
-		Token: newToken,
+		Token:    value,
+		WalletID: wallet.ID,
+		Currency: wallet.Currency,
 	}, nil
 }
 
-func (ws *walletService) Enable(req types.EnableRequest) (types.EnableResponse, error) {
-	wallet, err := ws.walletRepo.Enable(req.Token)
+func (ws *walletService) Enable(ctx context.Context, req types.EnableRequest) (types.EnableResponse, error) {
+	wallet, err := ws.walletRepo.Enable(ctx, req.Auth.Wallet.ID)
 	if err != nil {
 		log.Println("walletService.Enable.Enable", err)
 		return types.EnableResponse{}, err
@@ -56,31 +79,46 @@ func (ws *walletService) Enable(req types.EnableRequest) (types.EnableResponse,
 		Status:    wallet.GetStatusString(),
 		EnabledAt: wallet.UpdatedAt.Time,
 		Balance:   wallet.Balance,
+		Currency:  wallet.Currency,
 	}, nil
 }
 
-func (ws *walletService) ViewBalance(req types.ViewBalanceRequest) (types.ViewBalanceResponse, error) {
-	wallet, err := ws.walletRepo.GetByToken(req.Token)
-	if err != nil {
-		log.Println("walletService.ViewBalance", err)
-		return types.ViewBalanceResponse{}, err
-	}
+func (ws *walletService) ViewBalance(ctx context.Context, req types.ViewBalanceRequest) (types.ViewBalanceResponse, error) {
+	wallet := req.Auth.Wallet
 
 	if wallet.Status != int(types.StatusActive) {
-		return types.ViewBalanceResponse{}, errors.New("wallet is disabled")
+		return types.ViewBalanceResponse{}, types.ErrWalletInactive
+	}
+
+	if !wallet.Held.IsZero() {
+		err := ws.walletRepo.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+			err := expireHolds(ctx, repo, wallet.ID)
+			if err != nil {
+				return err
+			}
+
+			wallet, err = repo.GetByID(ctx, wallet.ID)
+			return err
+		})
+		if err != nil {
+			log.Println("walletService.ViewBalance.expireHolds", err)
+			return types.ViewBalanceResponse{}, err
+		}
 	}
 
 	return types.ViewBalanceResponse{
-		ID:        wallet.ID,
-		OwnedBy:   wallet.OwnedBy,
-		Status:    wallet.GetStatusString(),
-		EnabledAt: wallet.UpdatedAt.Time,
-		Balance:   wallet.Balance,
+		ID:               wallet.ID,
+		OwnedBy:          wallet.OwnedBy,
+		Status:           wallet.GetStatusString(),
+		EnabledAt:        wallet.UpdatedAt.Time,
+		Balance:          wallet.Balance,
+		AvailableBalance: wallet.AvailableBalance(),
+		Currency:         wallet.Currency,
 	}, nil
 }
 
-func (ws *walletService) Disable(req types.DisableRequest) (types.DisableResponse, error) {
-	wallet, err := ws.walletRepo.Disable(req.Token)
+func (ws *walletService) Disable(ctx context.Context, req types.DisableRequest) (types.DisableResponse, error) {
+	wallet, err := ws.walletRepo.Disable(ctx, req.Auth.Wallet.ID)
 	if err != nil {
 		log.Println("walletService.Disable.Disable", err)
 		return types.DisableResponse{}, err
@@ -92,132 +130,431 @@ func (ws *walletService) Disable(req types.DisableRequest) (types.DisableRespons
 		Status:     wallet.GetStatusString(),
 		DisabledAt: wallet.UpdatedAt.Time,
 		Balance:    wallet.Balance,
+		Currency:   wallet.Currency,
 	}, nil
 }
 
-func (ws *walletService) Deposit(req types.DepositRequest) (types.DepositResponse, error) {
-	wallet, err := ws.walletRepo.GetByToken(req.Token)
+func (ws *walletService) Deposit(ctx context.Context, req types.DepositRequest) (types.DepositResponse, error) {
+	wallet := req.Auth.Wallet
+
+	amount, err := walletAmount(wallet, req.Amount, req.Currency)
 	if err != nil {
-		log.Println("walletService.Deposit", err)
 		return types.DepositResponse{}, err
 	}
 
-	if wallet.Status != int(types.StatusActive) {
-		return types.DepositResponse{}, errors.New("wallet is inactive")
-	}
-
 	mutation := types.Mutation{
 		ID:          uuid.NewString(),
 		ReferenceID: req.ReferenceID,
 		CreatedAt:   time.Now(),
 		CreatedBy:   wallet.OwnedBy,
+		WalletID:    wallet.ID,
 		Action:      int(types.MutationActionDeposit),
 		Status:      int(types.MutationStatusSuccess),
-		Amount:      req.Amount,
+		Amount:      amount,
+		Currency:    wallet.Currency,
 	}
-	err = ws.walletRepo.Mutate(mutation, wallet.Balance+req.Amount, req.Token)
+	mutation, replayed, err := ws.applyMutation(ctx, wallet, mutation, mutateWallet(ctx, mutation))
 	if err != nil {
 		log.Println("walletService.Deposit", err)
 		return types.DepositResponse{}, err
 	}
 
 	return types.DepositResponse{
-		ID:          mutation.ID,
-		DepositedBy: mutation.CreatedBy,
-		Status:      mutation.GetStatusString(),
-		DepositedAt: mutation.CreatedAt,
-		Amount:      mutation.Amount,
-		ReferenceID: mutation.ReferenceID,
+		ID:            mutation.ID,
+		DepositedBy:   mutation.CreatedBy,
+		Status:        mutation.GetStatusString(),
+		DepositedAt:   mutation.CreatedAt,
+		Amount:        mutation.Amount,
+		Currency:      mutation.Currency,
+		ReferenceID:   mutation.ReferenceID,
+		FailureReason: mutation.FailureReason,
+		Replayed:      replayed,
 	}, nil
 }
 
-func (ws *walletService) Withdraw(req types.WithdrawRequest) (types.WithdrawResponse, error) {
-	wallet, err := ws.walletRepo.GetByToken(req.Token)
+func (ws *walletService) Withdraw(ctx context.Context, req types.WithdrawRequest) (types.WithdrawResponse, error) {
+	wallet := req.Auth.Wallet
+
+	amount, err := walletAmount(wallet, req.Amount, req.Currency)
 	if err != nil {
-		log.Println("walletService.Withdraw", err)
 		return types.WithdrawResponse{}, err
 	}
 
-	if wallet.Status != int(types.StatusActive) {
-		return types.WithdrawResponse{}, errors.New("wallet is inactive")
-	}
-
-	if wallet.Balance < req.Amount {
-		return types.WithdrawResponse{}, errors.New("insufficient funds")
-	}
-
 	mutation := types.Mutation{
 		ID:          uuid.NewString(),
 		ReferenceID: req.ReferenceID,
 		CreatedAt:   time.Now(),
 		CreatedBy:   wallet.OwnedBy,
+		WalletID:    wallet.ID,
 		Action:      int(types.MutationActionWithdraw),
 		Status:      int(types.MutationStatusSuccess),
-		Amount:      req.Amount,
+		Amount:      amount,
+		Currency:    wallet.Currency,
 	}
-	err = ws.walletRepo.Mutate(mutation, wallet.Balance-req.Amount, req.Token)
+	mutation, replayed, err := ws.applyMutation(ctx, wallet, mutation, mutateWallet(ctx, mutation))
 	if err != nil {
 		log.Println("walletService.Withdraw", err)
 		return types.WithdrawResponse{}, err
 	}
 
 	return types.WithdrawResponse{
-		ID:          mutation.ID,
-		WithdrawnBy: mutation.CreatedBy,
-		Status:      mutation.GetStatusString(),
-		WithdrawnAt: mutation.CreatedAt,
-		Amount:      mutation.Amount,
-		ReferenceID: mutation.ReferenceID,
+		ID:            mutation.ID,
+		WithdrawnBy:   mutation.CreatedBy,
+		Status:        mutation.GetStatusString(),
+		WithdrawnAt:   mutation.CreatedAt,
+		Amount:        mutation.Amount,
+		Currency:      mutation.Currency,
+		ReferenceID:   mutation.ReferenceID,
+		FailureReason: mutation.FailureReason,
+		Replayed:      replayed,
 	}, nil
 }
 
-func (ws *walletService) ListMutation(req types.MutationListRequest) ([]interface{}, error) {
-	wallet, err := ws.walletRepo.GetByToken(req.Token)
+// Transfer debits the caller's wallet and credits the recipient's in one
+// transaction, recording a linked mutation on each side.
+func (ws *walletService) Transfer(ctx context.Context, req types.TransferRequest) (types.TransferResponse, error) {
+	wallet := req.Auth.Wallet
+
+	recipient, err := ws.getTransferRecipient(ctx, req)
 	if err != nil {
-		log.Println("walletService.ListMutation", err)
-		return nil, err
+		log.Println("walletService.Transfer.getTransferRecipient", err)
+		return types.TransferResponse{}, err
 	}
 
-	list, err := ws.walletRepo.ListMutation(wallet.OwnedBy)
+	if recipient.ID == wallet.ID {
+		return types.TransferResponse{}, types.ErrSameWallet
+	}
+
+	// Moving money between currencies needs a conversion, not a transfer.
+	if recipient.Currency != wallet.Currency {
+		return types.TransferResponse{}, types.ErrCurrencyMismatch
+	}
+
+	amount, err := walletAmount(wallet, req.Amount, req.Currency)
 	if err != nil {
-		return nil, err
+		return types.TransferResponse{}, err
+	}
+
+	now := time.Now()
+	outgoing := types.Mutation{
+		ID:           uuid.NewString(),
+		ReferenceID:  req.ReferenceID,
+		CreatedAt:    now,
+		CreatedBy:    wallet.OwnedBy,
+		WalletID:     wallet.ID,
+		Action:       int(types.MutationActionTransferOut),
+		Status:       int(types.MutationStatusSuccess),
+		Amount:       amount,
+		Currency:     wallet.Currency,
+		Counterparty: recipient.ID,
+	}
+	// The incoming side has no client reference of its own, so it is
+	// keyed by the outgoing mutation ID to keep reference_id unique.
+	incoming := types.Mutation{
+		ID:           uuid.NewString(),
+		ReferenceID:  outgoing.ID,
+		CreatedAt:    now,
+		CreatedBy:    recipient.OwnedBy,
+		WalletID:     recipient.ID,
+		Action:       int(types.MutationActionTransferIn),
+		Status:       int(types.MutationStatusSuccess),
+		Amount:       amount,
+		Currency:     wallet.Currency,
+		RelatedID:    outgoing.ID,
+		Counterparty: wallet.ID,
 	}
+	outgoing.RelatedID = incoming.ID
 
-	var res []interface{}
+	mutation, replayed, err := ws.applyMutation(ctx, wallet, outgoing, func(repo types.WalletRepository) error {
+		current, err := repo.GetByID(ctx, recipient.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != int(types.StatusActive) {
+			return types.ErrRecipientInactive
+		}
+
+		if _, err = repo.Mutate(ctx, outgoing); err != nil {
+			return err
+		}
+
+		_, err = repo.Mutate(ctx, incoming)
+		return err
+	})
+	if err != nil {
+		log.Println("walletService.Transfer", err)
+		return types.TransferResponse{}, err
+	}
+
+	res := makeTransferResponse(mutation)
+	res.Replayed = replayed
+	return res, nil
+}
+
+func (ws *walletService) ListMutation(ctx context.Context, req types.MutationListRequest) (types.MutationListResponse, error) {
+	wallet := req.Auth.Wallet
+
+	filter, err := makeMutationFilter(wallet, req)
+	if err != nil {
+		return types.MutationListResponse{}, err
+	}
+
+	if filter.AfterID != "" {
+		after, err := ws.walletRepo.GetMutationByID(ctx, filter.AfterID)
+		if err != nil || after.WalletID != wallet.ID {
+			return types.MutationListResponse{}, types.ErrInvalidCursor
+		}
+	}
+
+	// One extra row tells whether there is a next page.
+	limit := filter.Limit
+	filter.Limit++
+	list, err := ws.walletRepo.ListMutation(ctx, filter)
+	if err != nil {
+		log.Println("walletService.ListMutation", err)
+		return types.MutationListResponse{}, err
+	}
+
+	var nextCursor string
+	if len(list) > limit {
+		list = list[:limit]
+		nextCursor = base64.RawURLEncoding.EncodeToString([]byte(list[limit-1].ID))
+	}
+
+	res := []interface{}{}
 	for _, mutation := range list {
 		switch mutation.Action {
 		case int(types.MutationActionDeposit):
 			res = append(res, types.DepositResponse{
-				ID:          mutation.ID,
-				DepositedBy: mutation.CreatedBy,
-				Status:      mutation.GetStatusString(),
-				DepositedAt: mutation.CreatedAt,
-				Amount:      mutation.Amount,
-				ReferenceID: mutation.ReferenceID,
+				ID:            mutation.ID,
+				DepositedBy:   mutation.CreatedBy,
+				Status:        mutation.GetStatusString(),
+				DepositedAt:   mutation.CreatedAt,
+				Amount:        mutation.Amount,
+				Currency:      mutation.Currency,
+				ReferenceID:   mutation.ReferenceID,
+				FailureReason: mutation.FailureReason,
 			})
 			break
 		case int(types.MutationActionWithdraw):
 			res = append(res, types.WithdrawResponse{
-				ID:          mutation.ID,
-				WithdrawnBy: mutation.CreatedBy,
-				Status:      mutation.GetStatusString(),
-				WithdrawnAt: mutation.CreatedAt,
-				Amount:      mutation.Amount,
-				ReferenceID: mutation.ReferenceID,
+				ID:            mutation.ID,
+				WithdrawnBy:   mutation.CreatedBy,
+				Status:        mutation.GetStatusString(),
+				WithdrawnAt:   mutation.CreatedAt,
+				Amount:        mutation.Amount,
+				Currency:      mutation.Currency,
+				ReferenceID:   mutation.ReferenceID,
+				FailureReason: mutation.FailureReason,
 			})
 			break
+		case int(types.MutationActionTransferOut), int(types.MutationActionTransferIn):
+			res = append(res, makeTransferResponse(mutation))
+		case int(types.MutationActionReverseDeposit), int(types.MutationActionReverseWithdraw):
+			res = append(res, makeReversalResponse(mutation))
+		case int(types.MutationActionConvertOut), int(types.MutationActionConvertIn):
+			res = append(res, makeConversionResponse(mutation))
 		}
 
 	}
 
-	return res, nil
+	return types.MutationListResponse{
+		Transactions: res,
+		NextCursor:   nextCursor,
+	}, nil
 }
 
 // helpers
 
-func makeToken() (string, error) {
-	length := 20
+// applyMutation applies a new mutation to the wallet unless one with the
+// same reference ID already exists. An earlier mutation for the same wallet,
+// action and amount is returned as a replay; any other reuse of the
+// reference ID is ErrReferenceConflict. apply performs the actual writes;
+// a mutation it rejects with one of the MutationFailure errors is still
+// recorded, as failed, once everything apply wrote has been rolled back.
+// A concurrent request that takes the reference ID first is handled as if
+// it had been found up front.
+func (ws *walletService) applyMutation(ctx context.Context, wallet types.Wallet, mutation types.Mutation, apply func(types.WalletRepository) error) (types.Mutation, bool, error) {
+	replayed := false
+	err := ws.walletRepo.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		var err error
+		if mutation, replayed, err = findReplay(ctx, repo, mutation); err != nil || replayed {
+			return err
+		}
+
+		// Lock both wallets of a transfer or conversion up front, before
+		// expiring holds writes to the first one, so that two going in
+		// opposite directions lock them in the same order.
+		wallets := []string{wallet.ID}
+		if mutation.Counterparty != "" {
+			wallets = append(wallets, mutation.Counterparty)
+		}
+		if err = repo.LockWallets(ctx, wallets...); err != nil {
+			return err
+		}
+
+		if err = expireHolds(ctx, repo, wallet.ID); err != nil {
+			return err
+		}
+
+		if wallet.Status != int(types.StatusActive) {
+			return types.ErrWalletInactive
+		}
+		return apply(repo)
+	})
+	if errors.Is(err, types.ErrDuplicateReference) {
+		mutation, replayed, err = ws.findConcurrentReplay(ctx, mutation, err)
+	}
 
+	reason, rejected := types.MutationFailureReason(err)
+	if !rejected {
+		if err == nil {
+			err = replayedFailure(mutation)
+		}
+		return mutation, replayed, err
+	}
+
+	// A rejected transfer or conversion never got a counterpart.
+	mutation.Status = int(types.MutationStatusFailed)
+	mutation.FailureReason = reason
+	switch types.MutationAction(mutation.Action) {
+	case types.MutationActionTransferOut, types.MutationActionConvertOut:
+		mutation.RelatedID = ""
+	}
+
+	err = ws.walletRepo.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		// Another request may have used the reference ID since.
+		var err error
+		if mutation, replayed, err = findReplay(ctx, repo, mutation); err != nil || replayed {
+			return err
+		}
+		return repo.CreateMutation(ctx, mutation)
+	})
+	if errors.Is(err, types.ErrDuplicateReference) {
+		mutation, replayed, err = ws.findConcurrentReplay(ctx, mutation, err)
+	}
+	if err != nil {
+		return mutation, replayed, err
+	}
+
+	return mutation, replayed, replayedFailure(mutation)
+}
+
+// replayedFailure returns the error a failed mutation was rejected with, so
+// a retry of a rejected request is rejected the same way.
+func replayedFailure(mutation types.Mutation) error {
+	if mutation.Status != int(types.MutationStatusFailed) {
+		return nil
+	}
+	return types.MutationFailureError(mutation.FailureReason)
+}
+
+// findReplay looks up the mutation already recorded under the reference ID
+// of mutation. It returns that mutation and true if it is a replay,
+// ErrReferenceConflict if it asked for something else, and mutation and
+// false if the reference ID is new.
+func findReplay(ctx context.Context, repo types.WalletRepository, mutation types.Mutation) (types.Mutation, bool, error) {
+	existing, err := repo.GetMutationByReferenceID(ctx, mutation.ReferenceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return mutation, false, nil
+	}
+	if err != nil {
+		return mutation, false, err
+	}
+
+	if !existing.SameRequest(mutation) {
+		return mutation, false, types.ErrReferenceConflict
+	}
+	return existing, true, nil
+}
+
+// findConcurrentReplay looks up the mutation of a concurrent request that
+// stored the reference ID after findReplay found it free: nothing locks a
+// reference ID before it is stored, so two retries can both get past
+// findReplay. It returns insertErr should the mutation not be found.
+func (ws *walletService) findConcurrentReplay(ctx context.Context, mutation types.Mutation, insertErr error) (types.Mutation, bool, error) {
+	existing, replayed, err := findReplay(ctx, ws.walletRepo, mutation)
+	if err == nil && !replayed {
+		return mutation, false, insertErr
+	}
+	return existing, replayed, err
+}
+
+func makeTransferResponse(mutation types.Mutation) types.TransferResponse {
+	return types.TransferResponse{
+		ID:            mutation.ID,
+		OwnedBy:       mutation.CreatedBy,
+		Direction:     mutation.GetTransferDirectionString(),
+		Counterparty:  mutation.Counterparty,
+		Status:        mutation.GetStatusString(),
+		TransferredAt: mutation.CreatedAt,
+		Amount:        mutation.Amount,
+		Currency:      mutation.Currency,
+		ReferenceID:   mutation.ReferenceID,
+		FailureReason: mutation.FailureReason,
+	}
+}
+
+// mutateWallet applies a single mutation to its wallet.
+func mutateWallet(ctx context.Context, mutation types.Mutation) func(types.WalletRepository) error {
+	return func(repo types.WalletRepository) error {
+		_, err := repo.Mutate(ctx, mutation)
+		return err
+	}
+}
+
+// getTransferRecipient finds the destination wallet of a transfer by wallet
+// ID or, failing that, by customer ID.
+func (ws *walletService) getTransferRecipient(ctx context.Context, req types.TransferRequest) (types.Wallet, error) {
+	var (
+		recipient types.Wallet
+		err       error
+	)
+	if req.ToWalletID != "" {
+		recipient, err = ws.walletRepo.GetByID(ctx, req.ToWalletID)
+	} else {
+		recipient, err = ws.getOnlyWalletOf(ctx, req.ToCustomerID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Wallet{}, types.ErrRecipientNotFound
+	}
+
+	return recipient, err
+}
+
+// getOnlyWalletOf returns the wallet of a customer who has exactly one;
+// with several it cannot tell which one is meant.
+func (ws *walletService) getOnlyWalletOf(ctx context.Context, customerID string) (types.Wallet, error) {
+	wallets, err := ws.walletRepo.ListByOwnedBy(ctx, customerID)
+	switch {
+	case err != nil:
+		return types.Wallet{}, err
+	case len(wallets) == 0:
+		return types.Wallet{}, sql.ErrNoRows
+	case len(wallets) > 1:
+		return types.Wallet{}, types.ErrRecipientAmbiguous
+	}
+
+	return wallets[0], nil
+}
+
+// walletAmount validates an amount given for the wallet, with an optional
+// currency code, and brings it to the scale of the wallet currency.
+func walletAmount(wallet types.Wallet, amount types.Money, currency string) (types.Money, error) {
+	if currency != "" {
+		code, err := types.NormalizeCurrency(currency)
+		if err != nil {
+			return types.Money{}, err
+		}
+		if code != wallet.Currency {
+			return types.Money{}, types.ErrCurrencyMismatch
+		}
+	}
+
+	return amount.Rescale(types.CurrencyExponent(wallet.Currency))
+}
+
+func makeToken(length int) (string, error) {
 	randomBytes := make([]byte, length)
 	_, err := rand.Read(randomBytes)
 	if err != nil {
@@ -227,3 +564,74 @@ func makeToken() (string, error) {
 
 	return hex.EncodeToString(randomBytes), nil
 }
+
+// makeMutationFilter validates the list request and turns it into a
+// repository filter. Actions may be repeated or comma separated.
+func makeMutationFilter(wallet types.Wallet, req types.MutationListRequest) (types.MutationFilter, error) {
+	var err error
+	filter := types.MutationFilter{
+		WalletID:    wallet.ID,
+		ReferenceID: req.ReferenceID,
+		From:        req.From,
+		To:          req.To,
+		Limit:       req.Limit,
+	}
+
+	for _, names := range req.Actions {
+		for _, name := range strings.Split(names, ",") {
+			action, ok := types.MutationActionMap[strings.TrimSpace(name)]
+			if !ok {
+				return types.MutationFilter{}, fmt.Errorf("%w: unknown action %q", types.ErrInvalidMutationFilter, name)
+			}
+			filter.Actions = append(filter.Actions, action)
+		}
+	}
+
+	if req.Status != "" {
+		for status, name := range types.MutationStatusMap {
+			if name == req.Status {
+				filter.Status = status
+			}
+		}
+		if filter.Status == 0 {
+			return types.MutationFilter{}, fmt.Errorf("%w: unknown status %q", types.ErrInvalidMutationFilter, req.Status)
+		}
+	}
+
+	if filter.MinAmount, err = rescaleAmountBound(req.MinAmount, wallet.Currency); err != nil {
+		return types.MutationFilter{}, err
+	}
+	if filter.MaxAmount, err = rescaleAmountBound(req.MaxAmount, wallet.Currency); err != nil {
+		return types.MutationFilter{}, err
+	}
+
+	switch {
+	case filter.Limit == 0:
+		filter.Limit = types.DefaultMutationListLimit
+	case filter.Limit < 0 || filter.Limit > types.MaxMutationListLimit:
+		return types.MutationFilter{}, fmt.Errorf("%w: limit must be between 1 and %d", types.ErrInvalidMutationFilter, types.MaxMutationListLimit)
+	}
+
+	if req.Cursor != "" {
+		id, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+		if err != nil {
+			return types.MutationFilter{}, types.ErrInvalidCursor
+		}
+		filter.AfterID = string(id)
+	}
+
+	return filter, nil
+}
+
+func rescaleAmountBound(bound *types.Money, currency string) (*types.Money, error) {
+	if bound == nil {
+		return nil, nil
+	}
+
+	amount, err := bound.Rescale(types.CurrencyExponent(currency))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", types.ErrInvalidMutationFilter, err)
+	}
+
+	return &amount, nil
+}
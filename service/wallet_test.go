@@ -0,0 +1,908 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otnayrus/simple-wallet-app/repository"
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+	types "github.com/otnayrus/simple-wallet-app/types/wallet"
+)
+
+func TestDepositReplaysReferenceAndRejectsConflicts(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+
+	req := types.DepositRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "deposit-1",
+		Amount:      types.NewMoney(10000, types.DefaultCurrencyExponent),
+	}
+	first, err := ws.Deposit(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Replayed {
+		t.Fatal("first deposit reported as replayed")
+	}
+
+	again, err := ws.Deposit(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Replayed || again.ID != first.ID {
+		t.Fatalf("retry = %+v, want a replay of %s", again, first.ID)
+	}
+
+	req.Amount = types.NewMoney(20000, types.DefaultCurrencyExponent)
+	if _, err = ws.Deposit(ctx, req); !errors.Is(err, types.ErrReferenceConflict) {
+		t.Fatalf("deposit with a reused reference_id: got %v, want %v", err, types.ErrReferenceConflict)
+	}
+
+	assertBalance(t, ws, token, types.NewMoney(10000, types.DefaultCurrencyExponent))
+}
+
+func TestWithdrawReplaysReferenceAndRejectsConflicts(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	alice := initEnabledWallet(t, ws, "alice")
+	bob := initEnabledWallet(t, ws, "bob")
+	deposit(t, ws, alice, "deposit-1", 10000)
+
+	req := types.WithdrawRequest{
+		Auth:        authenticate(t, ws, alice),
+		ReferenceID: "withdraw-1",
+		Amount:      types.NewMoney(2500, types.DefaultCurrencyExponent),
+	}
+	first, err := ws.Withdraw(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Auth = authenticate(t, ws, alice)
+	again, err := ws.Withdraw(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Replayed || again.ID != first.ID {
+		t.Fatalf("retry = %+v, want a replay of %s", again, first.ID)
+	}
+
+	conflicts := []struct {
+		name string
+		call func() error
+	}{
+		{"different amount", func() error {
+			_, err := ws.Withdraw(ctx, types.WithdrawRequest{
+				Auth:        authenticate(t, ws, alice),
+				ReferenceID: "withdraw-1",
+				Amount:      types.NewMoney(2600, types.DefaultCurrencyExponent),
+			})
+			return err
+		}},
+		{"different wallet", func() error {
+			_, err := ws.Withdraw(ctx, types.WithdrawRequest{
+				Auth:        authenticate(t, ws, bob),
+				ReferenceID: "withdraw-1",
+				Amount:      types.NewMoney(2500, types.DefaultCurrencyExponent),
+			})
+			return err
+		}},
+		{"different action", func() error {
+			_, err := ws.Deposit(ctx, types.DepositRequest{
+				Auth:        authenticate(t, ws, alice),
+				ReferenceID: "withdraw-1",
+				Amount:      types.NewMoney(2500, types.DefaultCurrencyExponent),
+			})
+			return err
+		}},
+	}
+	for _, tt := range conflicts {
+		if err := tt.call(); !errors.Is(err, types.ErrReferenceConflict) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, types.ErrReferenceConflict)
+		}
+	}
+
+	assertBalance(t, ws, alice, types.NewMoney(7500, types.DefaultCurrencyExponent))
+	assertBalance(t, ws, bob, types.NewMoney(0, types.DefaultCurrencyExponent))
+}
+
+func TestMutationLosingARaceForItsReferenceReplaysTheWinner(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryWalletRepository()
+	ws := NewWalletService(repo, types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+	deposit(t, ws, token, "deposit-1", 10000)
+
+	// Its transactions miss deposit-1 as if a concurrent retry had stored
+	// it after they looked, so only the insert finds it taken.
+	racing := NewWalletService(&staleReferenceRepository{repo}, types.DefaultTokenLength)
+
+	req := types.DepositRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "deposit-1",
+		Amount:      types.NewMoney(10000, types.DefaultCurrencyExponent),
+	}
+	res, err := racing.Deposit(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Replayed {
+		t.Errorf("retry losing the race = %+v, want a replay", res)
+	}
+
+	req.Amount = types.NewMoney(20000, types.DefaultCurrencyExponent)
+	if _, err = racing.Deposit(ctx, req); !errors.Is(err, types.ErrReferenceConflict) {
+		t.Errorf("deposit losing the race to another amount: got %v, want %v", err, types.ErrReferenceConflict)
+	}
+
+	// A rejected withdrawal loses the race when it is recorded as failed.
+	_, err = racing.Withdraw(ctx, types.WithdrawRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "deposit-1",
+		Amount:      types.NewMoney(100000, types.DefaultCurrencyExponent),
+	})
+	if !errors.Is(err, types.ErrReferenceConflict) {
+		t.Errorf("rejected withdrawal losing the race: got %v, want %v", err, types.ErrReferenceConflict)
+	}
+
+	assertBalance(t, ws, token, types.NewMoney(10000, types.DefaultCurrencyExponent))
+}
+
+func TestWithdrawOverTheBalanceIsRecordedAsFailed(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+	deposit(t, ws, token, "deposit-1", 1000)
+
+	_, err := ws.Withdraw(ctx, types.WithdrawRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "withdraw-1",
+		Amount:      types.NewMoney(1001, types.DefaultCurrencyExponent),
+	})
+	if !errors.Is(err, types.ErrInsufficientFunds) {
+		t.Fatalf("withdraw over the balance: got %v, want %v", err, types.ErrInsufficientFunds)
+	}
+
+	list, err := ws.ListMutation(ctx, types.MutationListRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "withdraw-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Transactions) != 1 {
+		t.Fatalf("got %d transactions for withdraw-1, want 1", len(list.Transactions))
+	}
+	got, ok := list.Transactions[0].(types.WithdrawResponse)
+	if !ok || got.Status != types.MutationStatusFailString || got.FailureReason != types.MutationFailureInsufficientFunds {
+		t.Errorf("recorded %+v, want a failed withdrawal for %s", list.Transactions[0], types.MutationFailureInsufficientFunds)
+	}
+
+	assertBalance(t, ws, token, types.NewMoney(1000, types.DefaultCurrencyExponent))
+}
+
+// A retry of a rejected request gets the original rejection, even once
+// the request could succeed, rather than the failed mutation as a replay.
+func TestRetryOfRejectedMutationIsRejectedAgain(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	alice := initEnabledWallet(t, ws, "alice")
+	bob := initEnabledWallet(t, ws, "bob")
+	deposit(t, ws, alice, "deposit-1", 1000)
+
+	for i := 0; i < 2; i++ {
+		if err := withdraw(t, ws, alice, "withdraw-1", 1001); !errors.Is(err, types.ErrInsufficientFunds) {
+			t.Fatalf("withdraw over the balance, attempt %d: got %v, want %v", i+1, err, types.ErrInsufficientFunds)
+		}
+		deposit(t, ws, alice, fmt.Sprintf("top-up-%d", i), 1000)
+	}
+
+	if _, err := ws.Disable(ctx, types.DisableRequest{Auth: authenticate(t, ws, bob)}); err != nil {
+		t.Fatal(err)
+	}
+	req := types.TransferRequest{
+		ToWalletID:  authenticate(t, ws, bob).Wallet.ID,
+		ReferenceID: "transfer-1",
+		Amount:      types.NewMoney(100, types.DefaultCurrencyExponent),
+	}
+	for i := 0; i < 2; i++ {
+		req.Auth = authenticate(t, ws, alice)
+		if _, err := ws.Transfer(ctx, req); !errors.Is(err, types.ErrRecipientInactive) || apperror.Status(err) != http.StatusConflict {
+			t.Fatalf("transfer to a disabled wallet, attempt %d: got %v, want a 409 %v", i+1, err, types.ErrRecipientInactive)
+		}
+	}
+
+	assertBalance(t, ws, alice, types.NewMoney(3000, types.DefaultCurrencyExponent))
+}
+
+// Only the failed mutation is left of a rejected operation: whatever apply
+// wrote before the rejection is rolled back.
+func TestRejectedMutationLeavesNoOtherRowsChanged(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength).(*walletService)
+	alice := authenticate(t, ws, initEnabledWallet(t, ws, "alice"))
+	bobToken := initEnabledWallet(t, ws, "bob")
+	bob := authenticate(t, ws, bobToken)
+
+	mutation := types.Mutation{
+		ID:          "withdraw-1",
+		ReferenceID: "withdraw-1",
+		CreatedAt:   time.Now(),
+		CreatedBy:   alice.Wallet.OwnedBy,
+		WalletID:    alice.Wallet.ID,
+		Action:      int(types.MutationActionWithdraw),
+		Status:      int(types.MutationStatusSuccess),
+		Amount:      types.NewMoney(500, types.DefaultCurrencyExponent),
+		Currency:    alice.Wallet.Currency,
+	}
+	_, _, err := ws.applyMutation(ctx, alice.Wallet, mutation, func(repo types.WalletRepository) error {
+		_, err := repo.Mutate(ctx, types.Mutation{
+			ID:          "deposit-1",
+			ReferenceID: "deposit-1",
+			CreatedAt:   time.Now(),
+			CreatedBy:   bob.Wallet.OwnedBy,
+			WalletID:    bob.Wallet.ID,
+			Action:      int(types.MutationActionDeposit),
+			Status:      int(types.MutationStatusSuccess),
+			Amount:      types.NewMoney(500, types.DefaultCurrencyExponent),
+			Currency:    bob.Wallet.Currency,
+		})
+		if err != nil {
+			return err
+		}
+		return types.ErrInsufficientFunds
+	})
+	if !errors.Is(err, types.ErrInsufficientFunds) {
+		t.Fatalf("got %v, want %v", err, types.ErrInsufficientFunds)
+	}
+
+	if _, err = ws.walletRepo.GetMutationByReferenceID(ctx, "deposit-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deposit written before the rejection: got %v, want it rolled back", err)
+	}
+	assertBalance(t, ws, bobToken, types.NewMoney(0, types.DefaultCurrencyExponent))
+
+	failed, err := ws.walletRepo.GetMutationByReferenceID(ctx, "withdraw-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Status != int(types.MutationStatusFailed) || failed.FailureReason != types.MutationFailureInsufficientFunds {
+		t.Errorf("recorded %+v, want a failed mutation for %s", failed, types.MutationFailureInsufficientFunds)
+	}
+}
+
+func TestCaptureOnWalletDisabledSinceAuthenticationKeepsTheHold(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+	deposit(t, ws, token, "deposit-1", 1000)
+
+	hold, err := ws.CreateHold(ctx, types.CreateHoldRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "hold-1",
+		Amount:      types.NewMoney(400, types.DefaultCurrencyExponent),
+		ExpiresIn:   3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := authenticate(t, ws, token)
+	if _, err = ws.Disable(ctx, types.DisableRequest{Auth: auth}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ws.CaptureHold(ctx, types.CaptureHoldRequest{Auth: auth, HoldID: hold.ID, ReferenceID: "capture-1"})
+	if !errors.Is(err, types.ErrWalletInactive) {
+		t.Fatalf("capture: got %v, want %v", err, types.ErrWalletInactive)
+	}
+
+	wallet, err := ws.(*walletService).walletRepo.GetByID(ctx, auth.Wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Balance.Cmp(types.NewMoney(1000, types.DefaultCurrencyExponent)) != 0 || wallet.Held.Cmp(hold.Amount) != 0 {
+		t.Errorf("wallet after capture: balance %s held %s, want 10.00 with 4.00 held", wallet.Balance, wallet.Held)
+	}
+	current, err := ws.(*walletService).walletRepo.GetHold(ctx, hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Status != int(types.HoldStatusActive) {
+		t.Errorf("hold status = %d, want active", current.Status)
+	}
+}
+
+func TestDepositRefusesAmountTooLargeForTheCurrency(t *testing.T) {
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+
+	amount, err := types.ParseMoney("999999999999999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ws.Deposit(context.Background(), types.DepositRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "deposit-1",
+		Amount:      amount,
+	})
+	if !errors.Is(err, types.ErrMoneyOverflow) || apperror.Status(err) != http.StatusBadRequest {
+		t.Fatalf("deposit of %s: got %v, want a 400 %v", amount, err, types.ErrMoneyOverflow)
+	}
+
+	assertBalance(t, ws, token, types.NewMoney(0, types.DefaultCurrencyExponent))
+}
+
+func TestDepositRefusesToOverflowTheBalance(t *testing.T) {
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+
+	const largest = math.MaxInt64 / 2
+	deposit(t, ws, token, "deposit-1", largest)
+	deposit(t, ws, token, "deposit-2", largest)
+
+	_, err := ws.Deposit(context.Background(), types.DepositRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "deposit-3",
+		Amount:      types.NewMoney(largest, types.DefaultCurrencyExponent),
+	})
+	if !errors.Is(err, types.ErrBalanceOverflow) || apperror.Status(err) != http.StatusUnprocessableEntity {
+		t.Fatalf("deposit past the largest balance: got %v, want a 422 %v", err, types.ErrBalanceOverflow)
+	}
+
+	assertBalance(t, ws, token, types.NewMoney(2*largest, types.DefaultCurrencyExponent))
+}
+
+func TestWalletAmountsFollowTheWalletCurrency(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+
+	if _, err := ws.Initialize(ctx, types.InitializeRequest{CustomerID: "alice", Currency: "XYZ"}); !errors.Is(err, types.ErrUnsupportedCurrency) {
+		t.Fatalf("initialize in XYZ: got %v, want %v", err, types.ErrUnsupportedCurrency)
+	}
+
+	res, err := ws.Initialize(ctx, types.InitializeRequest{CustomerID: "alice", Currency: "jpy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Currency != "JPY" {
+		t.Errorf("initialized in %q, want JPY", res.Currency)
+	}
+	auth := authenticate(t, ws, res.Token)
+	if _, err = ws.Enable(ctx, types.EnableRequest{Auth: auth}); err != nil {
+		t.Fatal(err)
+	}
+
+	dep, err := ws.Deposit(ctx, types.DepositRequest{
+		Auth:        authenticate(t, ws, res.Token),
+		ReferenceID: "deposit-1",
+		Amount:      types.NewMoney(1000, 0),
+		Currency:    "JPY",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep.Currency != "JPY" {
+		t.Errorf("deposit in %q, want JPY", dep.Currency)
+	}
+
+	rejected := []struct {
+		name    string
+		req     types.DepositRequest
+		wantErr error
+	}{
+		{"another currency", types.DepositRequest{Amount: types.NewMoney(1000, 0), Currency: "USD"}, types.ErrCurrencyMismatch},
+		{"fractional yen", types.DepositRequest{Amount: types.NewMoney(1050, 2)}, types.ErrMoneyPrecisionLost},
+	}
+	for i, tt := range rejected {
+		tt.req.Auth = authenticate(t, ws, res.Token)
+		tt.req.ReferenceID = fmt.Sprintf("rejected-%d", i)
+		_, err := ws.Deposit(ctx, tt.req)
+		if !errors.Is(err, tt.wantErr) || apperror.Status(err) != http.StatusBadRequest {
+			t.Errorf("deposit of %s: got %v, want a 400 %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// A transfer never converts between currencies.
+	usd := initEnabledWallet(t, ws, "bob")
+	_, err = ws.Transfer(ctx, types.TransferRequest{
+		Auth:        authenticate(t, ws, res.Token),
+		ToWalletID:  authenticate(t, ws, usd).Wallet.ID,
+		ReferenceID: "transfer-1",
+		Amount:      types.NewMoney(100, 0),
+	})
+	if !errors.Is(err, types.ErrCurrencyMismatch) {
+		t.Errorf("transfer from JPY to %s: got %v, want %v", types.DefaultCurrency, err, types.ErrCurrencyMismatch)
+	}
+
+	assertBalance(t, ws, res.Token, types.NewMoney(1000, 0))
+}
+
+func TestTransferMovesFundsOnlyWhenCovered(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	alice := initEnabledWallet(t, ws, "alice")
+	bob := initEnabledWallet(t, ws, "bob")
+
+	_, err := ws.Deposit(ctx, types.DepositRequest{
+		Auth:        authenticate(t, ws, alice),
+		ReferenceID: "deposit-1",
+		Amount:      types.NewMoney(10000, types.DefaultCurrencyExponent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ws.Transfer(ctx, types.TransferRequest{
+		Auth:        authenticate(t, ws, alice),
+		ToWalletID:  authenticate(t, ws, bob).Wallet.ID,
+		ReferenceID: "transfer-1",
+		Amount:      types.NewMoney(2500, types.DefaultCurrencyExponent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ws.Transfer(ctx, types.TransferRequest{
+		Auth:        authenticate(t, ws, alice),
+		ToWalletID:  authenticate(t, ws, bob).Wallet.ID,
+		ReferenceID: "transfer-2",
+		Amount:      types.NewMoney(7501, types.DefaultCurrencyExponent),
+	})
+	if !errors.Is(err, types.ErrInsufficientFunds) {
+		t.Fatalf("transfer over the balance: got %v, want %v", err, types.ErrInsufficientFunds)
+	}
+
+	assertBalance(t, ws, alice, types.NewMoney(7500, types.DefaultCurrencyExponent))
+	assertBalance(t, ws, bob, types.NewMoney(2500, types.DefaultCurrencyExponent))
+}
+
+// Retrying a full reversal replays it although nothing is left to reverse.
+func TestFullReversalRetryIsReplayed(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+	deposit(t, ws, token, "deposit-1", 1000)
+	original, err := ws.Deposit(ctx, types.DepositRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: "deposit-2",
+		Amount:      types.NewMoney(400, types.DefaultCurrencyExponent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := types.ReverseMutationRequest{MutationID: original.ID, ReferenceID: "reversal-1"}
+	first, err := ws.ReverseMutation(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ws.ReverseMutation(ctx, req)
+	if err != nil {
+		t.Fatalf("retry of a full reversal: %v", err)
+	}
+	if !again.Replayed || again.ID != first.ID || again.Amount.Cmp(first.Amount) != 0 {
+		t.Fatalf("retry = %+v, want a replay of %+v", again, first)
+	}
+
+	req.ReferenceID = "reversal-2"
+	if _, err = ws.ReverseMutation(ctx, req); !errors.Is(err, types.ErrReversalExceedsAmount) {
+		t.Fatalf("second full reversal: got %v, want %v", err, types.ErrReversalExceedsAmount)
+	}
+
+	assertBalance(t, ws, token, types.NewMoney(1000, types.DefaultCurrencyExponent))
+}
+
+func TestConvertFXReplaysReferenceOnlyForTheSameQuote(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryWalletRepository()
+	ws := NewWalletService(repo, types.DefaultTokenLength)
+	idr := initEnabledWallet(t, ws, "alice")
+	deposit(t, ws, idr, "deposit-1", 10000000)
+
+	res, err := ws.Initialize(ctx, types.InitializeRequest{CustomerID: "alice", Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ws.Enable(ctx, types.EnableRequest{Auth: authenticate(t, ws, res.Token)}); err != nil {
+		t.Fatal(err)
+	}
+
+	rate, _ := types.ParseRate("0.000064")
+	if _, err = NewFXService(repo.FX()).SetRate(ctx, types.SetFXRateRequest{Base: "IDR", Quote: "USD", Rate: rate}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two quotes for the same amount at the same rate differ only in ID.
+	var quoteIDs []string
+	for i := 0; i < 2; i++ {
+		quote, err := ws.CreateFXQuote(ctx, types.CreateFXQuoteRequest{
+			Auth:       authenticate(t, ws, idr),
+			ToWalletID: res.WalletID,
+			Amount:     types.NewMoney(1000000, types.DefaultCurrencyExponent),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		quoteIDs = append(quoteIDs, quote.ID)
+	}
+
+	req := types.ConvertFXRequest{Auth: authenticate(t, ws, idr), QuoteID: quoteIDs[0], ReferenceID: "convert-1"}
+	first, err := ws.ConvertFX(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := ws.ConvertFX(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Replayed || again.ID != first.ID {
+		t.Fatalf("retry = %+v, want a replay of %s", again, first.ID)
+	}
+
+	req.QuoteID = quoteIDs[1]
+	_, err = ws.ConvertFX(ctx, req)
+	if !errors.Is(err, types.ErrReferenceConflict) || apperror.Status(err) != http.StatusConflict {
+		t.Fatalf("conversion of another quote with a reused reference_id: got %v, want a 409 %v", err, types.ErrReferenceConflict)
+	}
+
+	assertBalance(t, ws, idr, types.NewMoney(9000000, types.DefaultCurrencyExponent))
+	assertBalance(t, ws, res.Token, types.NewMoney(64, 2))
+}
+
+func TestCustomerWalletsKeepSeparateHistories(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	first := initEnabledWallet(t, ws, "alice")
+	second := initEnabledWallet(t, ws, "alice")
+	deposit(t, ws, first, "deposit-1", 100)
+	deposit(t, ws, first, "deposit-2", 200)
+	deposit(t, ws, second, "deposit-3", 300)
+
+	assertBalance(t, ws, first, types.NewMoney(300, types.DefaultCurrencyExponent))
+	assertBalance(t, ws, second, types.NewMoney(300, types.DefaultCurrencyExponent))
+
+	res, err := ws.ListMutation(ctx, types.MutationListRequest{Auth: authenticate(t, ws, first), Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.NextCursor == "" {
+		t.Fatal("first wallet has one page of 1, want 2")
+	}
+
+	// A cursor only pages through the wallet it came from.
+	_, err = ws.ListMutation(ctx, types.MutationListRequest{Auth: authenticate(t, ws, second), Cursor: res.NextCursor})
+	if !errors.Is(err, types.ErrInvalidCursor) {
+		t.Errorf("cursor of another wallet: got %v, want %v", err, types.ErrInvalidCursor)
+	}
+
+	res, err = ws.ListMutation(ctx, types.MutationListRequest{Auth: authenticate(t, ws, second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Transactions) != 1 {
+		t.Errorf("second wallet lists %d transactions, want its own 1", len(res.Transactions))
+	}
+
+	// With two wallets, alice can only be paid by wallet ID.
+	bob := initEnabledWallet(t, ws, "bob")
+	deposit(t, ws, bob, "deposit-4", 1000)
+	_, err = ws.Transfer(ctx, types.TransferRequest{
+		Auth:         authenticate(t, ws, bob),
+		ToCustomerID: "alice",
+		ReferenceID:  "transfer-1",
+		Amount:       types.NewMoney(100, types.DefaultCurrencyExponent),
+	})
+	if !errors.Is(err, types.ErrRecipientAmbiguous) {
+		t.Fatalf("transfer to a customer with two wallets: got %v, want %v", err, types.ErrRecipientAmbiguous)
+	}
+
+	_, err = ws.Transfer(ctx, types.TransferRequest{
+		Auth:        authenticate(t, ws, bob),
+		ToWalletID:  authenticate(t, ws, second).Wallet.ID,
+		ReferenceID: "transfer-1",
+		Amount:      types.NewMoney(100, types.DefaultCurrencyExponent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, ws, first, types.NewMoney(300, types.DefaultCurrencyExponent))
+	assertBalance(t, ws, second, types.NewMoney(400, types.DefaultCurrencyExponent))
+}
+
+func TestListMutationFiltersAndPages(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+	deposit(t, ws, token, "deposit-1", 100)
+	deposit(t, ws, token, "deposit-2", 200)
+	deposit(t, ws, token, "deposit-3", 300)
+	if err := withdraw(t, ws, token, "withdraw-1", 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := withdraw(t, ws, token, "withdraw-2", 100000); !errors.Is(err, types.ErrInsufficientFunds) {
+		t.Fatalf("withdraw over the balance: got %v, want %v", err, types.ErrInsufficientFunds)
+	}
+
+	minAmount := types.NewMoney(2, 0)
+	tests := []struct {
+		name    string
+		req     types.MutationListRequest
+		wantIDs int
+	}{
+		{"every mutation", types.MutationListRequest{}, 5},
+		{"one action", types.MutationListRequest{Actions: []string{"deposit"}}, 3},
+		{"comma separated actions", types.MutationListRequest{Actions: []string{"deposit,withdraw"}}, 5},
+		{"failed only", types.MutationListRequest{Status: types.MutationStatusFailString}, 1},
+		{"from a minimum amount", types.MutationListRequest{MinAmount: &minAmount}, 3},
+		{"by reference", types.MutationListRequest{ReferenceID: "deposit-2"}, 1},
+	}
+	for _, tt := range tests {
+		tt.req.Auth = authenticate(t, ws, token)
+		res, err := ws.ListMutation(ctx, tt.req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(res.Transactions) != tt.wantIDs {
+			t.Errorf("%s: got %d transactions, want %d", tt.name, len(res.Transactions), tt.wantIDs)
+		}
+	}
+
+	seen := map[string]bool{}
+	req := types.MutationListRequest{Limit: 2}
+	for page := 1; ; page++ {
+		req.Auth = authenticate(t, ws, token)
+		res, err := ws.ListMutation(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range transactionIDs(res) {
+			if seen[id] {
+				t.Fatalf("page %d repeats %s", page, id)
+			}
+			seen[id] = true
+		}
+		if res.NextCursor == "" {
+			if page != 3 {
+				t.Errorf("got %d pages of 2 for 5 mutations, want 3", page)
+			}
+			break
+		}
+		req.Cursor = res.NextCursor
+	}
+	if len(seen) != 5 {
+		t.Errorf("pages held %d mutations, want 5", len(seen))
+	}
+
+	invalid := []struct {
+		req     types.MutationListRequest
+		wantErr error
+	}{
+		{types.MutationListRequest{Actions: []string{"steal"}}, types.ErrInvalidMutationFilter},
+		{types.MutationListRequest{Status: "pending"}, types.ErrInvalidMutationFilter},
+		{types.MutationListRequest{Limit: types.MaxMutationListLimit + 1}, types.ErrInvalidMutationFilter},
+		{types.MutationListRequest{Cursor: "not base64!"}, types.ErrInvalidCursor},
+		{types.MutationListRequest{Cursor: "bm8tc3VjaC1pZA"}, types.ErrInvalidCursor},
+	}
+	for _, tt := range invalid {
+		tt.req.Auth = authenticate(t, ws, token)
+		if _, err := ws.ListMutation(ctx, tt.req); !errors.Is(err, tt.wantErr) {
+			t.Errorf("list %+v: got %v, want %v", tt.req, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExportMutationStreamsTheDateRangeOldestFirst(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+	auth := authenticate(t, ws, token)
+
+	// More mutations in range than fit in one page of the export, and one
+	// on either side of it.
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	times := []time.Time{from.Add(-time.Second), to}
+	for i := 0; i < exportPageSize+2; i++ {
+		times = append(times, from.Add(time.Duration(i)*time.Minute))
+	}
+	for i, at := range times {
+		_, err := ws.(*walletService).walletRepo.Mutate(ctx, types.Mutation{
+			ID:          fmt.Sprintf("mutation-%04d", i),
+			ReferenceID: fmt.Sprintf("deposit-%04d", i),
+			CreatedAt:   at,
+			CreatedBy:   auth.Wallet.OwnedBy,
+			WalletID:    auth.Wallet.ID,
+			Action:      int(types.MutationActionDeposit),
+			Status:      int(types.MutationStatusSuccess),
+			Amount:      types.NewMoney(100, types.DefaultCurrencyExponent),
+			Currency:    auth.Wallet.Currency,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	req := types.MutationExportRequest{Auth: auth, Format: types.MutationExportFormatJSONLines, From: from, To: to}
+	if err := ws.ExportMutation(ctx, req, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []types.MutationExportRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record types.MutationExportRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != exportPageSize+2 {
+		t.Fatalf("exported %d records, want the %d in range", len(records), exportPageSize+2)
+	}
+	for i, record := range records {
+		if want := times[i+2]; !record.CreatedAt.Equal(want) {
+			t.Fatalf("record %d created at %s, want %s", i, record.CreatedAt, want)
+		}
+	}
+
+	buf.Reset()
+	req.Format = types.MutationExportFormatCSV
+	if err := ws.ExportMutation(ctx, req, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != exportPageSize+3 {
+		t.Errorf("CSV export has %d lines, want a header and %d records", lines, exportPageSize+2)
+	}
+
+	req.Format = "xlsx"
+	if err := ws.ExportMutation(ctx, req, &buf); !errors.Is(err, types.ErrUnknownExportFormat) {
+		t.Errorf("export as xlsx: got %v, want %v", err, types.ErrUnknownExportFormat)
+	}
+}
+
+// A token rotated out keeps working during its grace period but cannot
+// issue a token that outlives it.
+func TestExpiringTokenCannotIssueTokens(t *testing.T) {
+	ctx := context.Background()
+	ws := NewWalletService(repository.NewMemoryWalletRepository(), types.DefaultTokenLength)
+	token := initEnabledWallet(t, ws, "alice")
+
+	if _, err := ws.RotateToken(ctx, types.RotateTokenRequest{Auth: authenticate(t, ws, token), GracePeriod: 60}); err != nil {
+		t.Fatal(err)
+	}
+	auth := authenticate(t, ws, token)
+
+	_, err := ws.RotateToken(ctx, types.RotateTokenRequest{Auth: auth, GracePeriod: 60})
+	if !errors.Is(err, types.ErrTokenExpiring) || apperror.Status(err) != http.StatusForbidden {
+		t.Errorf("rotate a rotated token: got %v, want a 403 %v", err, types.ErrTokenExpiring)
+	}
+
+	_, err = ws.CreateToken(ctx, types.CreateTokenRequest{Auth: auth})
+	if !errors.Is(err, types.ErrTokenExpiring) || apperror.Status(err) != http.StatusForbidden {
+		t.Errorf("create a token with a rotated token: got %v, want a 403 %v", err, types.ErrTokenExpiring)
+	}
+
+	list, err := ws.ListTokens(ctx, types.ListTokensRequest{Auth: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Tokens) != 2 {
+		t.Errorf("wallet has %d tokens, want the rotated one and its successor", len(list.Tokens))
+	}
+}
+
+// initEnabledWallet creates and enables a wallet for the customer and
+// returns its token.
+func initEnabledWallet(t *testing.T, ws types.WalletService, customerID string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	res, err := ws.Initialize(ctx, types.InitializeRequest{CustomerID: customerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ws.Enable(ctx, types.EnableRequest{Auth: authenticate(t, ws, res.Token)}); err != nil {
+		t.Fatal(err)
+	}
+
+	return res.Token
+}
+
+// authenticate resolves the token like the middleware does at the start of
+// every request, so the wallet is current.
+func authenticate(t *testing.T, ws types.WalletService, token string) types.Auth {
+	t.Helper()
+
+	auth, err := ws.Authenticate(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return auth
+}
+
+func deposit(t *testing.T, ws types.WalletService, token, referenceID string, minor int64) {
+	t.Helper()
+
+	_, err := ws.Deposit(context.Background(), types.DepositRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: referenceID,
+		Amount:      types.NewMoney(minor, types.DefaultCurrencyExponent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func withdraw(t *testing.T, ws types.WalletService, token, referenceID string, minor int64) error {
+	t.Helper()
+
+	_, err := ws.Withdraw(context.Background(), types.WithdrawRequest{
+		Auth:        authenticate(t, ws, token),
+		ReferenceID: referenceID,
+		Amount:      types.NewMoney(minor, types.DefaultCurrencyExponent),
+	})
+	return err
+}
+
+// transactionIDs returns the IDs of the deposits and withdrawals listed.
+func transactionIDs(res types.MutationListResponse) []string {
+	var ids []string
+	for _, transaction := range res.Transactions {
+		switch tx := transaction.(type) {
+		case types.DepositResponse:
+			ids = append(ids, tx.ID)
+		case types.WithdrawResponse:
+			ids = append(ids, tx.ID)
+		}
+	}
+	return ids
+}
+
+func assertBalance(t *testing.T, ws types.WalletService, token string, want types.Money) {
+	t.Helper()
+
+	res, err := ws.ViewBalance(context.Background(), types.ViewBalanceRequest{Auth: authenticate(t, ws, token)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Balance.Cmp(want) != 0 {
+		t.Errorf("balance of %s = %s, want %s", res.OwnedBy, res.Balance, want)
+	}
+}
+
+// staleReferenceRepository hides stored mutations from the reference ID
+// lookups of its transactions.
+type staleReferenceRepository struct {
+	types.WalletRepository
+}
+
+func (r *staleReferenceRepository) WithinTransaction(ctx context.Context, fn func(types.WalletRepository) error) error {
+	return r.WalletRepository.WithinTransaction(ctx, func(repo types.WalletRepository) error {
+		return fn(staleReferenceTx{repo})
+	})
+}
+
+type staleReferenceTx struct {
+	types.WalletRepository
+}
+
+func (staleReferenceTx) GetMutationByReferenceID(context.Context, string) (types.Mutation, error) {
+	return types.Mutation{}, sql.ErrNoRows
+}
@@ -0,0 +1,133 @@
+// Package apperror defines the errors reported to API clients. Each error
+// has a kind, which decides the HTTP status, a stable code clients can
+// branch on and a message meant for people.
+package apperror
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	// KindUnprocessable is a valid request the wallet cannot honour, such
+	// as a withdrawal over the balance.
+	KindUnprocessable
+	KindUnsupportedMediaType
+	// KindUnavailable is work the server gave up on, such as a request
+	// that ran past its deadline.
+	KindUnavailable
+)
+
+// CodeInternal is reported for every error that is not an *Error. Its
+// details stay in the server log.
+const CodeInternal = "internal_error"
+
+var kindStatus = map[Kind]int{
+	KindInternal:             http.StatusInternalServerError,
+	KindValidation:           http.StatusBadRequest,
+	KindUnauthorized:         http.StatusUnauthorized,
+	KindForbidden:            http.StatusForbidden,
+	KindNotFound:             http.StatusNotFound,
+	KindConflict:             http.StatusConflict,
+	KindUnprocessable:        http.StatusUnprocessableEntity,
+	KindUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	KindUnavailable:          http.StatusServiceUnavailable,
+}
+
+var (
+	// ErrTimeout is reported for work cancelled by a request deadline.
+	ErrTimeout = New(KindUnavailable, "timeout", "request timed out")
+	// ErrCanceled is reported for work cancelled because the client went
+	// away, which the client will rarely see.
+	ErrCanceled = New(KindUnavailable, "canceled", "request was canceled")
+)
+
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+// New returns an error to be declared once as a package variable and
+// compared with errors.Is. Add context by wrapping it with fmt.Errorf and
+// %w; the code and status are kept.
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func Validation(code, message string) *Error {
+	return New(KindValidation, code, message)
+}
+
+func NotFound(code, message string) *Error {
+	return New(KindNotFound, code, message)
+}
+
+func Conflict(code, message string) *Error {
+	return New(KindConflict, code, message)
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Status returns the HTTP status for err, 500 unless err is or wraps an
+// *Error, a deadline or a cancellation.
+func Status(err error) int {
+	e, ok := find(err)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	if status, ok := kindStatus[e.Kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Code returns the code of err, CodeInternal unless err is or wraps an
+// *Error, a deadline or a cancellation.
+func Code(err error) string {
+	e, ok := find(err)
+	if !ok {
+		return CodeInternal
+	}
+	return e.Code
+}
+
+// Message returns the client-facing message of err. Errors wrapping an
+// *Error keep the context added while wrapping.
+func Message(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return err.Error()
+	}
+	if known, ok := find(err); ok {
+		return known.Message
+	}
+	return "internal server error"
+}
+
+// find returns the *Error err is or wraps. Exceeded deadlines and
+// cancellations, which come from the standard library, are reported as
+// ErrTimeout and ErrCanceled.
+func find(err error) (*Error, bool) {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e, true
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout, true
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled, true
+	}
+	return nil, false
+}
@@ -0,0 +1,34 @@
+package apperror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestStatusAndCodeSurviveWrapping(t *testing.T) {
+	errMissing := NotFound("thing_not_found", "thing not found")
+
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{errMissing, http.StatusNotFound, "thing_not_found"},
+		{fmt.Errorf("loading: %w", errMissing), http.StatusNotFound, "thing_not_found"},
+		{New(KindUnprocessable, "broke", "broke"), http.StatusUnprocessableEntity, "broke"},
+		{errors.New("disk on fire"), http.StatusInternalServerError, CodeInternal},
+		{fmt.Errorf("querying: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, "timeout"},
+		{fmt.Errorf("querying: %w", context.Canceled), http.StatusServiceUnavailable, "canceled"},
+	}
+	for _, tt := range tests {
+		if got := Status(tt.err); got != tt.wantStatus {
+			t.Errorf("Status(%v) = %d, want %d", tt.err, got, tt.wantStatus)
+		}
+		if got := Code(tt.err); got != tt.wantCode {
+			t.Errorf("Code(%v) = %q, want %q", tt.err, got, tt.wantCode)
+		}
+	}
+}
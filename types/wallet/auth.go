@@ -0,0 +1,24 @@
+package types
+
+import "context"
+
+// Auth is what a request acts as: the token it was made with and the
+// wallet of that token. It is resolved once per request, before the
+// handler runs.
+type Auth struct {
+	Token  Token
+	Wallet Wallet
+}
+
+type authContextKey struct{}
+
+// NewAuthContext returns a copy of ctx carrying auth.
+func NewAuthContext(ctx context.Context, auth Auth) context.Context {
+	return context.WithValue(ctx, authContextKey{}, auth)
+}
+
+// AuthFromContext returns the Auth stored by NewAuthContext, if any.
+func AuthFromContext(ctx context.Context) (Auth, bool) {
+	auth, ok := ctx.Value(authContextKey{}).(Auth)
+	return auth, ok
+}
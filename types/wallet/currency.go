@@ -0,0 +1,64 @@
+package types
+
+import (
+	"strings"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
+
+// DefaultCurrency is the currency of wallets created without one, and of
+// every wallet created before wallets had a currency.
+const DefaultCurrency = "IDR"
+
+var (
+	ErrUnsupportedCurrency = apperror.Validation("unsupported_currency", "unsupported currency")
+	ErrCurrencyMismatch    = apperror.Validation("currency_mismatch", "currency does not match the wallet currency")
+)
+
+// currencyExponents lists the supported ISO 4217 codes with the number of
+// minor-unit digits of each.
+var currencyExponents = map[string]int{
+	"AUD": 2,
+	"BHD": 3,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"IDR": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MYR": 2,
+	"PHP": 2,
+	"SGD": 2,
+	"THB": 2,
+	"USD": 2,
+	"VND": 0,
+}
+
+// NormalizeCurrency returns the upper-case ISO 4217 code, defaulting to
+// DefaultCurrency when empty, or ErrUnsupportedCurrency.
+func NormalizeCurrency(code string) (string, error) {
+	if code == "" {
+		return DefaultCurrency, nil
+	}
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if _, ok := currencyExponents[code]; !ok {
+		return "", ErrUnsupportedCurrency
+	}
+
+	return code, nil
+}
+
+// CurrencyExponent is the number of minor-unit digits of a supported
+// currency. Amounts of the currency are stored as integers at this scale.
+func CurrencyExponent(code string) int {
+	if exponent, ok := currencyExponents[code]; ok {
+		return exponent
+	}
+	return DefaultCurrencyExponent
+}
@@ -0,0 +1,73 @@
+package types
+
+import (
+	"time"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
+
+type MutationExportFormat string
+
+const (
+	MutationExportFormatCSV       MutationExportFormat = "csv"
+	MutationExportFormatJSONLines MutationExportFormat = "jsonl"
+	MutationExportFormatOFX       MutationExportFormat = "ofx"
+)
+
+var ErrUnknownExportFormat = apperror.Validation("unknown_export_format", "unknown export format, expected csv, jsonl or ofx")
+
+var mutationExportContentTypes = map[MutationExportFormat]string{
+	MutationExportFormatCSV:       "text/csv; charset=utf-8",
+	MutationExportFormatJSONLines: "application/x-ndjson",
+	MutationExportFormatOFX:       "application/x-ofx",
+}
+
+type (
+	// MutationExportRequest selects the statement to export. From is
+	// inclusive and To exclusive; either may be left out.
+	MutationExportRequest struct {
+		Auth   Auth                 `form:"-" json:"-"`
+		Format MutationExportFormat `form:"format"`
+		From   time.Time            `form:"from"`
+		To     time.Time            `form:"to"`
+	}
+
+	// MutationExportRecord is one statement line. Amount is signed: it is
+	// negative for money leaving the wallet.
+	MutationExportRecord struct {
+		ID                   string    `json:"id"`
+		CreatedAt            time.Time `json:"created_at"`
+		Action               string    `json:"action"`
+		Status               string    `json:"status"`
+		Amount               Money     `json:"amount"`
+		Currency             string    `json:"currency"`
+		ReferenceID          string    `json:"reference_id"`
+		CounterpartyWalletID string    `json:"counterparty_wallet_id,omitempty"`
+		RelatedID            string    `json:"related_mutation_id,omitempty"`
+		FailureReason        string    `json:"failure_reason,omitempty"`
+		FXRate               string    `json:"fx_rate,omitempty"`
+	}
+)
+
+// ContentType is the media type of the format, and false if the format is
+// not supported.
+func (f MutationExportFormat) ContentType() (string, bool) {
+	contentType, ok := mutationExportContentTypes[f]
+	return contentType, ok
+}
+
+func NewMutationExportRecord(m Mutation) MutationExportRecord {
+	return MutationExportRecord{
+		ID:                   m.ID,
+		CreatedAt:            m.CreatedAt,
+		Action:               m.GetActionString(),
+		Status:               m.GetStatusString(),
+		Amount:               m.BalanceDelta(),
+		Currency:             m.Currency,
+		ReferenceID:          m.ReferenceID,
+		CounterpartyWalletID: m.Counterparty,
+		RelatedID:            m.RelatedID,
+		FailureReason:        m.FailureReason,
+		FXRate:               m.FXRate,
+	}
+}
@@ -0,0 +1,217 @@
+package types
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
+
+// DefaultFXQuoteDuration is how long a quoted rate can be used for a
+// conversion.
+const DefaultFXQuoteDuration = time.Minute
+
+var (
+	ErrInvalidRate        = apperror.Validation("invalid_rate", "invalid exchange rate")
+	ErrRateNotFound       = apperror.NotFound("rate_not_found", "no exchange rate for the currency pair")
+	ErrQuoteNotFound      = apperror.NotFound("quote_not_found", "quote not found")
+	ErrQuoteExpired       = apperror.Conflict("quote_expired", "quote has expired")
+	ErrQuoteUsed          = apperror.Conflict("quote_used", "quote was already used")
+	ErrSameCurrency       = apperror.Validation("same_currency", "wallets have the same currency, use a transfer")
+	ErrConversionTarget   = apperror.Validation("invalid_conversion_target", "conversion target must be another wallet of the same customer")
+	ErrConversionTooSmall = apperror.Validation("conversion_too_small", "amount converts to less than one minor unit")
+)
+
+type FXService interface {
+	SetRate(context.Context, SetFXRateRequest) (FXRateResponse, error)
+	// LoadRates reads rates from CSV with the columns base, quote, rate and
+	// effective_at, and returns how many were stored.
+	LoadRates(context.Context, io.Reader) (int, error)
+}
+
+type FXRepository interface {
+	CreateRate(context.Context, FXRate) error
+	// GetRate returns the rate for the pair that took effect last at or
+	// before the given time, or ErrRateNotFound.
+	GetRate(ctx context.Context, base, quote string, at time.Time) (FXRate, error)
+	CreateQuote(context.Context, FXQuote) error
+	GetQuote(ctx context.Context, id string) (FXQuote, error)
+	// UseQuote marks an unused quote as used, or returns ErrQuoteUsed.
+	UseQuote(ctx context.Context, id string, at time.Time) error
+}
+
+// Rate is an exact exchange rate: Units * 10^-Scale units of the quote
+// currency for one unit of the base currency.
+type Rate struct {
+	Units int64
+	Scale int
+}
+
+// ParseRate parses a positive decimal such as "15650.25" or "0.0000639".
+func ParseRate(s string) (Rate, error) {
+	m, err := ParseMoney(s)
+	if err != nil || !m.IsPositive() {
+		return Rate{}, ErrInvalidRate
+	}
+
+	return Rate{Units: m.Minor, Scale: m.Exponent}, nil
+}
+
+func (r Rate) IsZero() bool {
+	return r.Units == 0
+}
+
+func (r Rate) String() string {
+	return NewMoney(r.Units, r.Scale).String()
+}
+
+// Convert returns amount, in the base currency, expressed in the quote
+// currency with the given exponent. Fractions of the smallest unit are
+// truncated. It returns ErrMoneyOverflow if the result does not fit.
+func (r Rate) Convert(amount Money, exponent int) (Money, error) {
+	n := new(big.Int).Mul(big.NewInt(amount.Minor), big.NewInt(r.Units))
+	n.Mul(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil))
+	d := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(amount.Exponent+r.Scale)), nil)
+
+	if n.Quo(n, d); !n.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+	return NewMoney(n.Int64(), exponent), nil
+}
+
+// MarshalJSON renders the rate as an exact JSON number.
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a quoted decimal string, and is
+// also used by gin for form values.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return fmt.Errorf("%w: %q", err, s)
+	}
+
+	*r = parsed
+	return nil
+}
+
+// Value stores the rate as its decimal text so no precision is lost.
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+func (r *Rate) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Rate", src)
+	}
+
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+
+	*r = parsed
+	return nil
+}
+
+type (
+	FXRate struct {
+		Base        string    `db:"base"`
+		Quote       string    `db:"quote"`
+		Rate        Rate      `db:"rate"`
+		EffectiveAt time.Time `db:"effective_at"`
+		CreatedAt   time.Time `db:"created_at"`
+	}
+
+	// FXQuote fixes the rate for converting Amount from one wallet to
+	// another until ExpiresAt. A quote can be used once.
+	FXQuote struct {
+		ID              string       `db:"id"`
+		WalletID        string       `db:"wallet_id"`
+		ToWalletID      string       `db:"to_wallet_id"`
+		FromCurrency    string       `db:"from_currency"`
+		ToCurrency      string       `db:"to_currency"`
+		Rate            Rate         `db:"rate"`
+		Amount          Money        `db:"amount"`
+		ConvertedAmount Money        `db:"converted_amount"`
+		CreatedAt       time.Time    `db:"created_at"`
+		ExpiresAt       time.Time    `db:"expires_at"`
+		UsedAt          sql.NullTime `db:"used_at"`
+	}
+
+	SetFXRateRequest struct {
+		Base  string `form:"base" json:"base"`
+		Quote string `form:"quote" json:"quote"`
+		Rate  Rate   `form:"rate" json:"rate"`
+		// EffectiveAt defaults to now.
+		EffectiveAt time.Time `form:"effective_at" json:"effective_at"`
+	}
+
+	FXRateResponse struct {
+		Base        string    `json:"base"`
+		Quote       string    `json:"quote"`
+		Rate        Rate      `json:"rate"`
+		EffectiveAt time.Time `json:"effective_at"`
+	}
+
+	CreateFXQuoteRequest struct {
+		Auth       Auth   `form:"-" json:"-"`
+		ToWalletID string `form:"to_wallet_id" json:"to_wallet_id"`
+		// Amount is in the currency of the caller's wallet.
+		Amount Money `form:"amount" json:"amount"`
+	}
+
+	FXQuoteResponse struct {
+		ID              string    `json:"id"`
+		WalletID        string    `json:"wallet_id"`
+		ToWalletID      string    `json:"to_wallet_id"`
+		FromCurrency    string    `json:"from_currency"`
+		ToCurrency      string    `json:"to_currency"`
+		Rate            Rate      `json:"rate"`
+		Amount          Money     `json:"amount"`
+		ConvertedAmount Money     `json:"converted_amount"`
+		ExpiresAt       time.Time `json:"expires_at"`
+	}
+
+	ConvertFXRequest struct {
+		Auth        Auth   `form:"-" json:"-"`
+		QuoteID     string `form:"quote_id" json:"quote_id"`
+		ReferenceID string `form:"reference_id" json:"reference_id"`
+	}
+
+	ConversionResponse struct {
+		ID           string    `json:"id"`
+		OwnedBy      string    `json:"owned_by"`
+		Direction    string    `json:"direction"`
+		Counterparty string    `json:"counterparty_wallet_id"`
+		Status       string    `json:"status"`
+		ConvertedAt  time.Time `json:"converted_at"`
+		Amount       Money     `json:"amount"`
+		Currency     string    `json:"currency"`
+		FXRate       string    `json:"fx_rate"`
+		ReferenceID  string    `json:"reference_id"`
+		// FailureReason explains a "fail" status, see MutationFailureReason.
+		FailureReason string `json:"failure_reason,omitempty"`
+		// Replayed is set when the reference ID had already been processed
+		// and this is the original result rather than a new mutation.
+		Replayed bool `json:"-"`
+	}
+)
+
+func (q *FXQuote) IsExpired(now time.Time) bool {
+	return !now.Before(q.ExpiresAt)
+}
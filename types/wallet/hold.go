@@ -0,0 +1,114 @@
+package types
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
+
+// DefaultHoldDuration is how long a hold reserves funds when the request
+// does not say otherwise.
+const DefaultHoldDuration = 7 * 24 * time.Hour
+
+type HoldStatus int
+
+const (
+	HoldStatusActive HoldStatus = iota + 1
+	HoldStatusCaptured
+	HoldStatusVoided
+	HoldStatusExpired
+)
+
+const (
+	HoldStatusActiveString   string = "active"
+	HoldStatusCapturedString string = "captured"
+	HoldStatusVoidedString   string = "voided"
+	HoldStatusExpiredString  string = "expired"
+)
+
+var (
+	ErrHoldNotFound       = apperror.NotFound("hold_not_found", "hold not found")
+	ErrHoldNotActive      = apperror.Conflict("hold_not_active", "hold is no longer active")
+	ErrCaptureExceedsHold = apperror.Validation("capture_exceeds_hold", "capture amount exceeds the held amount")
+)
+
+var (
+	holdStatusToString = map[HoldStatus]string{
+		HoldStatusActive:   HoldStatusActiveString,
+		HoldStatusCaptured: HoldStatusCapturedString,
+		HoldStatusVoided:   HoldStatusVoidedString,
+		HoldStatusExpired:  HoldStatusExpiredString,
+	}
+)
+
+func (h *Hold) GetStatusString() string {
+	return holdStatusToString[HoldStatus(h.Status)]
+}
+
+// IsExpired reports whether an active hold has passed its expiry time.
+func (h *Hold) IsExpired(now time.Time) bool {
+	return HoldStatus(h.Status) == HoldStatusActive && !now.Before(h.ExpiresAt)
+}
+
+type (
+	// Hold reserves part of a wallet balance. While active its amount is
+	// counted in Wallet.Held, lowering the available balance but not the
+	// balance itself, until it is captured, voided or expires.
+	Hold struct {
+		ID             string       `db:"id"`
+		WalletID       string       `db:"wallet_id"`
+		ReferenceID    string       `db:"reference_id"`
+		Status         int          `db:"status"`
+		Amount         Money        `db:"amount"`
+		CapturedAmount Money        `db:"captured_amount"`
+		Currency       string       `db:"currency"`
+		MutationID     string       `db:"mutation_id"`
+		CreatedAt      time.Time    `db:"created_at"`
+		ExpiresAt      time.Time    `db:"expires_at"`
+		UpdatedAt      sql.NullTime `db:"updated_at"`
+	}
+
+	CreateHoldRequest struct {
+		Auth        Auth   `form:"-" json:"-"`
+		ReferenceID string `form:"reference_id" json:"reference_id"`
+		Amount      Money  `form:"amount" json:"amount"`
+		// Currency is optional and must match the wallet currency.
+		Currency string `form:"currency" json:"currency"`
+		// ExpiresIn is the lifetime of the hold in seconds.
+		ExpiresIn int `form:"expires_in" json:"expires_in"`
+	}
+
+	CaptureHoldRequest struct {
+		Auth        Auth   `form:"-" json:"-"`
+		HoldID      string `form:"-" json:"-"`
+		ReferenceID string `form:"reference_id" json:"reference_id"`
+		// Amount defaults to the full held amount when zero.
+		Amount Money `form:"amount" json:"amount"`
+	}
+
+	VoidHoldRequest struct {
+		Auth   Auth `form:"-" json:"-"`
+		HoldID string
+	}
+
+	HoldResponse struct {
+		ID             string    `json:"id"`
+		WalletID       string    `json:"wallet_id"`
+		Status         string    `json:"status"`
+		Amount         Money     `json:"amount"`
+		CapturedAmount Money     `json:"captured_amount"`
+		Currency       string    `json:"currency"`
+		ReferenceID    string    `json:"reference_id"`
+		CreatedAt      time.Time `json:"created_at"`
+		ExpiresAt      time.Time `json:"expires_at"`
+		// Replayed is set when the reference ID had already been processed
+		// and this is the original result rather than a new hold.
+		Replayed bool `json:"-"`
+	}
+
+	CaptureHoldResponse struct {
+		Hold       HoldResponse     `json:"hold"`
+		Withdrawal WithdrawResponse `json:"withdrawal"`
+	}
+)
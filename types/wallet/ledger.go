@@ -0,0 +1,181 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type LedgerRepository interface {
+	CreateAccount(context.Context, LedgerAccount) error
+	// PostJournalEntry stores an entry and its postings. Entries whose
+	// postings do not sum to zero are rejected with ErrUnbalancedEntry.
+	PostJournalEntry(context.Context, JournalEntry) error
+	ListAccountBalances(context.Context) ([]AccountBalance, error)
+}
+
+type LedgerService interface {
+	TrialBalance(context.Context) (TrialBalanceResponse, error)
+}
+
+type LedgerAccountKind int
+
+const (
+	LedgerAccountKindSystem LedgerAccountKind = iota + 1
+	LedgerAccountKindWallet
+)
+
+// System accounts are the other side of every posting to a wallet account.
+// Money entering the platform is drawn from cash-in, money leaving it goes
+// to cash-out, and transfers pass through the clearing account. Conversions
+// pass through the FX account, whose balance in each currency is the
+// platform's position in it.
+const (
+	LedgerAccountCashIn           string = "system:cash_in"
+	LedgerAccountCashOut          string = "system:cash_out"
+	LedgerAccountFees             string = "system:fees"
+	LedgerAccountTransferClearing string = "system:transfer_clearing"
+	LedgerAccountOpeningBalance   string = "system:opening_balance"
+	LedgerAccountFX               string = "system:fx"
+)
+
+var ErrUnbalancedEntry = errors.New("journal entry postings do not sum to zero")
+
+var (
+	SystemLedgerAccounts = []LedgerAccount{
+		{ID: LedgerAccountCashIn, Kind: int(LedgerAccountKindSystem), Name: "Cash in"},
+		{ID: LedgerAccountCashOut, Kind: int(LedgerAccountKindSystem), Name: "Cash out"},
+		{ID: LedgerAccountFees, Kind: int(LedgerAccountKindSystem), Name: "Fees"},
+		{ID: LedgerAccountTransferClearing, Kind: int(LedgerAccountKindSystem), Name: "Transfer clearing"},
+		{ID: LedgerAccountOpeningBalance, Kind: int(LedgerAccountKindSystem), Name: "Opening balances"},
+		{ID: LedgerAccountFX, Kind: int(LedgerAccountKindSystem), Name: "Currency exchange"},
+	}
+
+	mutationActionToSystemAccount = map[MutationAction]string{
+		MutationActionDeposit:     LedgerAccountCashIn,
+		MutationActionWithdraw:    LedgerAccountCashOut,
+		MutationActionTransferOut: LedgerAccountTransferClearing,
+		MutationActionTransferIn:  LedgerAccountTransferClearing,
+		MutationActionConvertOut:  LedgerAccountFX,
+		MutationActionConvertIn:   LedgerAccountFX,
+		// Reversals undo the original posting against the same account.
+		MutationActionReverseDeposit:  LedgerAccountCashIn,
+		MutationActionReverseWithdraw: LedgerAccountCashOut,
+	}
+
+	mutationActionDescription = map[MutationAction]string{
+		MutationActionDeposit:         "deposit",
+		MutationActionWithdraw:        "withdrawal",
+		MutationActionTransferOut:     "transfer out",
+		MutationActionTransferIn:      "transfer in",
+		MutationActionReverseDeposit:  "deposit reversal",
+		MutationActionReverseWithdraw: "withdrawal reversal",
+		MutationActionConvertOut:      "conversion out",
+		MutationActionConvertIn:       "conversion in",
+	}
+)
+
+type (
+	LedgerAccount struct {
+		ID   string `db:"id"`
+		Kind int    `db:"kind"`
+		Name string `db:"name"`
+	}
+
+	JournalEntry struct {
+		ID          string    `db:"id"`
+		MutationID  string    `db:"mutation_id"`
+		CreatedAt   time.Time `db:"created_at"`
+		Description string    `db:"description"`
+		Postings    []Posting
+	}
+
+	// Posting is a signed change to one account. A positive amount
+	// increases the account balance.
+	Posting struct {
+		EntryID   string `db:"entry_id"`
+		AccountID string `db:"account_id"`
+		Amount    Money  `db:"amount"`
+		Currency  string `db:"currency"`
+	}
+
+	// AccountBalance is the balance of an account in one currency. System
+	// accounts have one per currency they have seen.
+	AccountBalance struct {
+		AccountID string
+		Kind      int
+		Name      string
+		Balance   Money
+		Currency  string
+	}
+
+	TrialBalanceResponse struct {
+		Accounts []AccountBalanceResponse `json:"accounts"`
+		// Totals has the sum of all balances per currency.
+		Totals   []CurrencyTotalResponse `json:"totals"`
+		Balanced bool                    `json:"balanced"`
+	}
+
+	AccountBalanceResponse struct {
+		AccountID string `json:"account_id"`
+		Name      string `json:"name"`
+		Balance   Money  `json:"balance"`
+		Currency  string `json:"currency"`
+	}
+
+	CurrencyTotalResponse struct {
+		Currency string `json:"currency"`
+		Total    Money  `json:"total"`
+	}
+)
+
+// WalletLedgerAccountID is the ledger account that mirrors a wallet balance.
+func WalletLedgerAccountID(walletID string) string {
+	return "wallet:" + walletID
+}
+
+func NewWalletLedgerAccount(wallet Wallet) LedgerAccount {
+	return LedgerAccount{
+		ID:   WalletLedgerAccountID(wallet.ID),
+		Kind: int(LedgerAccountKindWallet),
+		Name: "Wallet of " + wallet.OwnedBy,
+	}
+}
+
+// NewMutationJournalEntry builds the entry for a successful mutation on the
+// given wallet: the wallet account moves by the mutation's balance delta
+// and the matching system account absorbs the opposite amount, both in the
+// mutation's currency.
+func NewMutationJournalEntry(m Mutation, walletID string) JournalEntry {
+	delta := m.BalanceDelta()
+	return JournalEntry{
+		ID:          m.ID,
+		MutationID:  m.ID,
+		CreatedAt:   m.CreatedAt,
+		Description: mutationActionDescription[MutationAction(m.Action)],
+		Postings: []Posting{
+			{EntryID: m.ID, AccountID: WalletLedgerAccountID(walletID), Amount: delta, Currency: m.Currency},
+			{EntryID: m.ID, AccountID: mutationActionToSystemAccount[MutationAction(m.Action)], Amount: delta.Neg(), Currency: m.Currency},
+		},
+	}
+}
+
+// IsBalanced reports whether the postings of each currency sum to zero.
+// Postings too large to sum never balance.
+func (e *JournalEntry) IsBalanced() bool {
+	totals := map[string]Money{}
+	for _, p := range e.Postings {
+		total, err := totals[p.Currency].Add(p.Amount)
+		if err != nil {
+			return false
+		}
+		totals[p.Currency] = total
+	}
+
+	for _, total := range totals {
+		if !total.IsZero() {
+			return false
+		}
+	}
+	return len(e.Postings) > 0
+}
@@ -0,0 +1,261 @@
+package types
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
+
+// DefaultCurrencyExponent is the number of minor-unit digits of the
+// default currency, e.g. 2 means amounts are stored in cents. Amounts of
+// other currencies use the exponent from CurrencyExponent.
+const DefaultCurrencyExponent = 2
+
+const maxMoneyDigits = 18
+
+var (
+	ErrInvalidMoney       = apperror.Validation("invalid_amount", "invalid money amount")
+	ErrMoneyPrecisionLost = apperror.Validation("amount_precision_lost", "amount has more decimal places than the currency allows")
+	ErrMoneyOverflow      = apperror.Validation("amount_out_of_range", "amount is too large")
+)
+
+// Money is an exact monetary amount held as an integer count of minor
+// units together with the currency exponent, so that 1050 with exponent
+// 2 represents 10.50. It is never converted to a float.
+type Money struct {
+	Minor    int64
+	Exponent int
+}
+
+func NewMoney(minor int64, exponent int) Money {
+	return Money{
+		Minor:    minor,
+		Exponent: exponent,
+	}
+}
+
+// ParseMoney parses a plain decimal string such as "100", "-2.5" or
+// "10.05". The resulting exponent is the number of fractional digits
+// given; use Rescale to bring it to the currency exponent.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+		if fracPart == "" {
+			return Money{}, ErrInvalidMoney
+		}
+	}
+	if intPart == "" || len(intPart)+len(fracPart) > maxMoneyDigits || !isDigits(intPart) || !isDigits(fracPart) {
+		return Money{}, ErrInvalidMoney
+	}
+
+	minor, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidMoney
+	}
+	if neg {
+		minor = -minor
+	}
+
+	return NewMoney(minor, len(fracPart)), nil
+}
+
+// Rescale returns the same amount expressed with the given exponent. It
+// fails rather than rounding when digits would be dropped, and with
+// ErrMoneyOverflow when the amount no longer fits.
+func (m Money) Rescale(exponent int) (Money, error) {
+	if exponent == m.Exponent {
+		return m, nil
+	}
+
+	if exponent > m.Exponent {
+		minor := m.Minor
+		for i := m.Exponent; i < exponent; i++ {
+			if minor > math.MaxInt64/10 || minor < math.MinInt64/10 {
+				return Money{}, ErrMoneyOverflow
+			}
+			minor *= 10
+		}
+		return NewMoney(minor, exponent), nil
+	}
+
+	var divisor int64 = 1
+	for i := exponent; i < m.Exponent; i++ {
+		if divisor > math.MaxInt64/10 {
+			// Every non-zero amount has a digit below the divisor.
+			if m.Minor != 0 {
+				return Money{}, ErrMoneyPrecisionLost
+			}
+			return NewMoney(0, exponent), nil
+		}
+		divisor *= 10
+	}
+	if m.Minor%divisor != 0 {
+		return Money{}, ErrMoneyPrecisionLost
+	}
+
+	return NewMoney(m.Minor/divisor, exponent), nil
+}
+
+// Add returns m+o, or ErrMoneyOverflow when the sum does not fit.
+func (m Money) Add(o Money) (Money, error) {
+	a, b, err := align(m, o)
+	if err != nil {
+		return Money{}, err
+	}
+
+	sum := a.Minor + b.Minor
+	if (b.Minor > 0 && sum < a.Minor) || (b.Minor < 0 && sum > a.Minor) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return NewMoney(sum, a.Exponent), nil
+}
+
+// Sub returns m-o, or ErrMoneyOverflow when the difference does not fit.
+func (m Money) Sub(o Money) (Money, error) {
+	a, b, err := align(m, o)
+	if err != nil {
+		return Money{}, err
+	}
+
+	diff := a.Minor - b.Minor
+	if (b.Minor < 0 && diff < a.Minor) || (b.Minor > 0 && diff > a.Minor) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return NewMoney(diff, a.Exponent), nil
+}
+
+func (m Money) Neg() Money {
+	return NewMoney(-m.Minor, m.Exponent)
+}
+
+// Cmp returns -1, 0 or 1 depending on whether m is less than, equal to or
+// greater than o.
+func (m Money) Cmp(o Money) int {
+	a, b, err := align(m, o)
+	if err != nil {
+		// The amount that overflowed at the finer exponent is the larger
+		// in magnitude, so its sign decides.
+		if m.Exponent < o.Exponent {
+			return sign(m.Minor)
+		}
+		return -sign(o.Minor)
+	}
+	switch {
+	case a.Minor < b.Minor:
+		return -1
+	case a.Minor > b.Minor:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Minor > 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+func (m Money) String() string {
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	digits := strconv.FormatInt(minor, 10)
+	if m.Exponent <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= m.Exponent {
+		digits = strings.Repeat("0", m.Exponent-len(digits)+1) + digits
+	}
+
+	cut := len(digits) - m.Exponent
+	return sign + digits[:cut] + "." + digits[cut:]
+}
+
+// MarshalJSON renders the amount as an exact JSON number, e.g. 10.50.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts either a JSON number or a quoted decimal string.
+// It is also what gin uses when binding a form value into a Money field.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return fmt.Errorf("%w: %q", err, s)
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Value stores the amount as integer minor units.
+func (m Money) Value() (driver.Value, error) {
+	return m.Minor, nil
+}
+
+// Scan reads integer minor units. The exponent is assumed to be
+// DefaultCurrencyExponent; callers that know the currency set the right
+// one after scanning.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*m = NewMoney(v, DefaultCurrencyExponent)
+	case nil:
+		*m = NewMoney(0, DefaultCurrencyExponent)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+// helpers
+
+// align rescales the amount with the smaller exponent to the other's,
+// which never drops digits but may overflow.
+func align(a, b Money) (Money, Money, error) {
+	var err error
+	switch {
+	case a.Exponent < b.Exponent:
+		a, err = a.Rescale(b.Exponent)
+	case a.Exponent > b.Exponent:
+		b, err = b.Rescale(a.Exponent)
+	}
+	return a, b, err
+}
+
+func sign(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyArithmeticRefusesToOverflow(t *testing.T) {
+	max := NewMoney(math.MaxInt64, 2)
+	min := NewMoney(math.MinInt64, 2)
+	one := NewMoney(1, 2)
+
+	tests := []struct {
+		name    string
+		op      func() (Money, error)
+		want    Money
+		wantErr error
+	}{
+		{"rescale up to the limit", func() (Money, error) { return NewMoney(math.MaxInt64/100, 0).Rescale(2) }, NewMoney(math.MaxInt64/100*100, 2), nil},
+		{"rescale up past the limit", func() (Money, error) { return NewMoney(999999999999999999, 0).Rescale(2) }, Money{}, ErrMoneyOverflow},
+		{"rescale negative past the limit", func() (Money, error) { return NewMoney(-999999999999999999, 0).Rescale(2) }, Money{}, ErrMoneyOverflow},
+		{"rescale down by more than int64 digits", func() (Money, error) { return NewMoney(1, 30).Rescale(0) }, Money{}, ErrMoneyPrecisionLost},
+		{"add up to the limit", func() (Money, error) { return NewMoney(math.MaxInt64-1, 2).Add(one) }, max, nil},
+		{"add past the limit", func() (Money, error) { return max.Add(one) }, Money{}, ErrMoneyOverflow},
+		{"add past the negative limit", func() (Money, error) { return min.Add(one.Neg()) }, Money{}, ErrMoneyOverflow},
+		{"add overflowing the alignment", func() (Money, error) { return NewMoney(math.MaxInt64/10+1, 1).Add(one) }, Money{}, ErrMoneyOverflow},
+		{"sub down to the limit", func() (Money, error) { return NewMoney(math.MinInt64+1, 2).Sub(one) }, min, nil},
+		{"sub past the limit", func() (Money, error) { return min.Sub(one) }, Money{}, ErrMoneyOverflow},
+		{"sub a negative past the limit", func() (Money, error) { return max.Sub(one.Neg()) }, Money{}, ErrMoneyOverflow},
+		{"convert", func() (Money, error) { return Rate{Units: 1565025, Scale: 2}.Convert(NewMoney(150, 2), 2) }, NewMoney(2347537, 2), nil},
+		{"convert past the limit", func() (Money, error) { return Rate{Units: 15650, Scale: 0}.Convert(max, 2) }, Money{}, ErrMoneyOverflow},
+	}
+	for _, tt := range tests {
+		got, err := tt.op()
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMoneyCmpOrdersAmountsThatCannotBeAligned(t *testing.T) {
+	huge := NewMoney(math.MaxInt64, 0)
+	cent := NewMoney(1, 2)
+
+	if huge.Cmp(cent) != 1 || cent.Cmp(huge) != -1 || huge.Neg().Cmp(cent) != -1 {
+		t.Errorf("Cmp of %s and %s does not follow their values", huge, cent)
+	}
+}
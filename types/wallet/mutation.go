@@ -1,19 +1,74 @@
 package types
 
-import "time"
+import (
+	"time"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
 
 type (
 	Mutation struct {
 		ID          string    `db:"id"`
 		ReferenceID string    `db:"reference_id"`
 		CreatedAt   time.Time `db:"created_at"`
-		CreatedBy   string    `db:"created_by"`
-		Action      int       `db:"action"`
-		Status      int       `db:"status"`
-		Amount      float64   `db:"amount"`
+		// CreatedBy is the customer owning the wallet, WalletID the
+		// wallet itself.
+		CreatedBy string `db:"created_by"`
+		WalletID  string `db:"wallet_id"`
+		Action    int    `db:"action"`
+		Status    int    `db:"status"`
+		Amount    Money  `db:"amount"`
+		// Currency is the currency of the wallet, and of Amount.
+		Currency string `db:"currency"`
+		// FailureReason is one of the MutationFailure codes when Status is
+		// MutationStatusFailed, and empty otherwise.
+		FailureReason string `db:"failure_reason"`
+		// RelatedID links the mutation to its counterpart, e.g. the other
+		// side of a transfer.
+		RelatedID string `db:"related_id"`
+		// Counterparty is the ID of the wallet on the other side of a
+		// transfer.
+		Counterparty string `db:"counterparty"`
+		// ReversedAmount is how much of the mutation has been refunded by
+		// reversals so far.
+		ReversedAmount Money `db:"reversed_amount"`
+		// FXRate is the exchange rate a conversion was made at, and empty
+		// for every other action.
+		FXRate string `db:"fx_rate"`
+		// QuoteID is the FX quote a conversion carried out, and empty for
+		// every other action.
+		QuoteID string `db:"quote_id"`
+	}
+
+	// MutationFilter selects mutations of one wallet for ListMutation. Zero
+	// values mean no restriction, except Limit which must be positive.
+	MutationFilter struct {
+		WalletID    string
+		Actions     []MutationAction
+		Status      MutationStatus
+		MinAmount   *Money
+		MaxAmount   *Money
+		From        time.Time
+		To          time.Time
+		ReferenceID string
+		// AfterID is the last mutation of the previous page.
+		AfterID string
+		// Ascending lists the oldest mutations first.
+		Ascending bool
+		Limit     int
 	}
 )
 
+const (
+	DefaultMutationListLimit = 50
+	MaxMutationListLimit     = 200
+)
+
+var (
+	ErrInvalidMutationFilter = apperror.Validation("invalid_filter", "invalid transaction filter")
+	ErrInvalidCursor         = apperror.Validation("invalid_cursor", "invalid cursor")
+)
+
 type MutationStatus int
 type MutationAction int
 
@@ -25,6 +80,12 @@ const (
 const (
 	MutationActionDeposit MutationAction = iota + 1
 	MutationActionWithdraw
+	MutationActionTransferOut
+	MutationActionTransferIn
+	MutationActionReverseDeposit
+	MutationActionReverseWithdraw
+	MutationActionConvertOut
+	MutationActionConvertIn
 )
 
 const (
@@ -32,13 +93,117 @@ const (
 	MutationStatusFailString    string = "fail"
 )
 
+const (
+	TransferDirectionOutString string = "out"
+	TransferDirectionInString  string = "in"
+)
+
+// Failure reasons are the codes of the errors the mutation was rejected
+// with.
+const (
+	MutationFailureWalletInactive    string = "wallet_inactive"
+	MutationFailureInsufficientFunds string = "insufficient_funds"
+	MutationFailureRecipientInactive string = "recipient_inactive"
+	MutationFailureBalanceOverflow   string = "balance_out_of_range"
+)
+
 var (
 	MutationStatusMap = map[MutationStatus]string{
 		MutationStatusSuccess: MutationStatusSuccessString,
 		MutationStatusFailed:  MutationStatusFailString,
 	}
+
+	MutationActionMap = map[string]MutationAction{
+		"deposit":          MutationActionDeposit,
+		"withdraw":         MutationActionWithdraw,
+		"transfer_out":     MutationActionTransferOut,
+		"transfer_in":      MutationActionTransferIn,
+		"reverse_deposit":  MutationActionReverseDeposit,
+		"reverse_withdraw": MutationActionReverseWithdraw,
+		"convert_out":      MutationActionConvertOut,
+		"convert_in":       MutationActionConvertIn,
+	}
+
+	mutationFailureToError = map[string]error{
+		MutationFailureWalletInactive:    ErrWalletInactive,
+		MutationFailureInsufficientFunds: ErrInsufficientFunds,
+		MutationFailureRecipientInactive: ErrRecipientInactive,
+		MutationFailureBalanceOverflow:   ErrBalanceOverflow,
+	}
 )
 
+// MutationFailureReason returns the failure code recorded for a mutation
+// rejected with err, and false if err is not such a rejection.
+func MutationFailureReason(err error) (string, bool) {
+	for reason, e := range mutationFailureToError {
+		if err == e {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// MutationFailureError returns the error a failed mutation was rejected with.
+func MutationFailureError(reason string) error {
+	return mutationFailureToError[reason]
+}
+
 func (m *Mutation) GetStatusString() string {
 	return MutationStatusMap[MutationStatus(m.Status)]
 }
+
+func (m *Mutation) GetActionString() string {
+	for name, action := range MutationActionMap {
+		if MutationAction(m.Action) == action {
+			return name
+		}
+	}
+	return ""
+}
+
+// ReversalAction is the action of the mutation that compensates m, and
+// false if m cannot be reversed.
+func (m *Mutation) ReversalAction() (MutationAction, bool) {
+	switch MutationAction(m.Action) {
+	case MutationActionDeposit:
+		return MutationActionReverseDeposit, true
+	case MutationActionWithdraw:
+		return MutationActionReverseWithdraw, true
+	}
+	return 0, false
+}
+
+// ReversibleAmount is the part of m not yet refunded by reversals.
+func (m *Mutation) ReversibleAmount() Money {
+	// Both amounts are in the currency of m and never negative, so the
+	// difference cannot overflow.
+	reversible, _ := m.Amount.Sub(m.ReversedAmount)
+	return reversible
+}
+
+func (m *Mutation) GetTransferDirectionString() string {
+	switch MutationAction(m.Action) {
+	case MutationActionTransferIn, MutationActionConvertIn:
+		return TransferDirectionInString
+	}
+	return TransferDirectionOutString
+}
+
+// BalanceDelta is the signed change the mutation makes to a wallet balance.
+func (m *Mutation) BalanceDelta() Money {
+	switch MutationAction(m.Action) {
+	case MutationActionWithdraw, MutationActionTransferOut, MutationActionConvertOut, MutationActionReverseDeposit:
+		return m.Amount.Neg()
+	}
+	return m.Amount
+}
+
+// SameRequest reports whether o asks for the same change as m, which is
+// what makes reusing a reference ID an idempotent replay.
+func (m *Mutation) SameRequest(o Mutation) bool {
+	return m.WalletID == o.WalletID &&
+		m.Action == o.Action &&
+		m.Counterparty == o.Counterparty &&
+		m.QuoteID == o.QuoteID &&
+		m.Amount.Cmp(o.Amount) == 0
+}
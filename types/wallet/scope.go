@@ -0,0 +1,103 @@
+package types
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
+
+// Scope is a permission granted to a token.
+type Scope string
+
+const (
+	ScopeBalanceRead      Scope = "balance:read"
+	ScopeTransactionsRead Scope = "transactions:read"
+	ScopeDeposit          Scope = "deposit"
+	// ScopeWithdraw covers everything that takes money out of the wallet
+	// or reserves it: withdrawals, transfers, holds and conversions.
+	ScopeWithdraw Scope = "withdraw"
+	// ScopeAdmin covers enabling and disabling the wallet and managing its
+	// tokens, and implies every other scope.
+	ScopeAdmin Scope = "admin"
+)
+
+// AllScopes are the scopes of a new wallet's token, and of tokens created
+// before tokens had scopes.
+var AllScopes = Scopes{ScopeAdmin, ScopeBalanceRead, ScopeTransactionsRead, ScopeDeposit, ScopeWithdraw}
+
+var (
+	ErrInvalidScope = apperror.Validation("invalid_scope", "unknown scope")
+	ErrScopeMissing = apperror.New(apperror.KindForbidden, "scope_missing", "token does not have the required scope")
+)
+
+// Scopes are stored space-separated.
+type Scopes []Scope
+
+// ParseScopes validates scope names, dropping duplicates.
+func ParseScopes(names []string) (Scopes, error) {
+	var scopes Scopes
+	for _, name := range names {
+		scope := Scope(strings.TrimSpace(name))
+		if !scope.IsValid() {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, name)
+		}
+		if !scopes.contains(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+func (s Scope) IsValid() bool {
+	return AllScopes.contains(s)
+}
+
+// Has reports whether the scopes grant scope, either directly or through
+// ScopeAdmin.
+func (s Scopes) Has(scope Scope) bool {
+	return s.contains(scope) || s.contains(ScopeAdmin)
+}
+
+func (s Scopes) String() string {
+	names := make([]string, len(s))
+	for i, scope := range s {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, " ")
+}
+
+func (s Scopes) Value() (driver.Value, error) {
+	return s.String(), nil
+}
+
+func (s *Scopes) Scan(src interface{}) error {
+	var v string
+	switch src := src.(type) {
+	case string:
+		v = src
+	case []byte:
+		v = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into Scopes", src)
+	}
+
+	parsed, err := ParseScopes(strings.Fields(v))
+	if err != nil {
+		return err
+	}
+
+	*s = parsed
+	return nil
+}
+
+func (s Scopes) contains(scope Scope) bool {
+	for _, have := range s {
+		if have == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package types
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
+)
+
+const (
+	// MaxTokenGracePeriod is the longest a rotated token keeps working.
+	MaxTokenGracePeriod = 24 * time.Hour
+
+	// TokenPrefixLength is how many leading characters of a token are
+	// stored in clear to find its hash.
+	TokenPrefixLength = 8
+
+	// DefaultTokenLength is how many random bytes a new token has. It is
+	// written as twice as many hex characters.
+	DefaultTokenLength = 20
+
+	tokenSaltLength = 16
+)
+
+var (
+	ErrTokenNotFound      = apperror.NotFound("token_not_found", "token not found")
+	ErrInvalidGracePeriod = apperror.Validation("invalid_grace_period", "grace_period must be between 0 and 86400 seconds")
+	// ErrTokenExpiring is returned when a token set to expire, such as one
+	// rotated out, tries to issue a token that would outlive it.
+	ErrTokenExpiring = apperror.New(apperror.KindForbidden, "token_expiring", "an expiring token cannot issue new tokens")
+)
+
+type (
+	// Token is one credential of a wallet. A wallet can have several; each
+	// works until it expires or is revoked. Only a salted hash of the token
+	// is kept, see HashToken.
+	Token struct {
+		ID        string    `db:"id"`
+		WalletID  string    `db:"wallet_id"`
+		Prefix    string    `db:"prefix"`
+		Hash      string    `db:"hash"`
+		Scopes    Scopes    `db:"scopes"`
+		CreatedAt time.Time `db:"created_at"`
+		// ExpiresAt is set when the token was rotated out with a grace
+		// period.
+		ExpiresAt sql.NullTime `db:"expires_at"`
+		RevokedAt sql.NullTime `db:"revoked_at"`
+	}
+
+	ListTokensRequest struct {
+		Auth Auth `form:"-" json:"-"`
+	}
+
+	CreateTokenRequest struct {
+		Auth Auth `form:"-" json:"-"`
+		// Scopes default to those of the token the request is made with.
+		Scopes []string `form:"scope" json:"scopes"`
+	}
+
+	RotateTokenRequest struct {
+		Auth Auth `form:"-" json:"-"`
+		// GracePeriod is how many seconds the rotated token keeps
+		// working; zero invalidates it at once.
+		GracePeriod int `form:"grace_period" json:"grace_period"`
+	}
+
+	RevokeTokenRequest struct {
+		Auth    Auth `form:"-" json:"-"`
+		TokenID string
+	}
+
+	TokenResponse struct {
+		ID string `json:"id"`
+		// Token is only returned when the token is created; afterwards the
+		// prefix tells tokens apart.
+		Token     string     `json:"token,omitempty"`
+		Prefix    string     `json:"prefix"`
+		Scopes    Scopes     `json:"scopes"`
+		CreatedAt time.Time  `json:"created_at"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		RevokedAt *time.Time `json:"revoked_at,omitempty"`
+		// Current marks the token the request was made with.
+		Current bool `json:"current"`
+	}
+
+	TokenListResponse struct {
+		Tokens []TokenResponse `json:"tokens"`
+	}
+)
+
+// IsValid reports whether the token can still be used at the given time.
+func (t *Token) IsValid(now time.Time) bool {
+	if t.RevokedAt.Valid {
+		return false
+	}
+	return !t.ExpiresAt.Valid || now.Before(t.ExpiresAt.Time)
+}
+
+// TokenPrefix is the part of a token stored in clear.
+func TokenPrefix(token string) string {
+	if len(token) > TokenPrefixLength {
+		return token[:TokenPrefixLength]
+	}
+	return token
+}
+
+// HashToken returns a salted SHA-256 hash of the token as "salt$digest" in
+// hex. Tokens are long random strings, so a fast hash is enough.
+func HashToken(token string) (string, error) {
+	salt := make([]byte, tokenSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(salt) + "$" + tokenDigest(salt, token), nil
+}
+
+// Matches reports whether token is the one t was hashed from.
+func (t *Token) Matches(token string) bool {
+	parts := strings.SplitN(t.Hash, "$", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(tokenDigest(salt, token)), []byte(parts[1])) == 1
+}
+
+func tokenDigest(salt []byte, token string) string {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), token...))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,31 +1,139 @@
 package types
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"io"
 	"time"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
 )
 
 type WalletService interface {
-	Initialize(InitializeRequest) (InitializeResponse, error)
-	Enable(EnableRequest) (EnableResponse, error)
-	ViewBalance(ViewBalanceRequest) (ViewBalanceResponse, error)
-	Disable(DisableRequest) (DisableResponse, error)
-	Deposit(DepositRequest) (DepositResponse, error)
-	Withdraw(WithdrawRequest) (WithdrawResponse, error)
-	ListMutation(MutationListRequest) ([]interface{}, error)
+	Initialize(context.Context, InitializeRequest) (InitializeResponse, error)
+	Enable(context.Context, EnableRequest) (EnableResponse, error)
+	ViewBalance(context.Context, ViewBalanceRequest) (ViewBalanceResponse, error)
+	Disable(context.Context, DisableRequest) (DisableResponse, error)
+	Deposit(context.Context, DepositRequest) (DepositResponse, error)
+	Withdraw(context.Context, WithdrawRequest) (WithdrawResponse, error)
+	Transfer(context.Context, TransferRequest) (TransferResponse, error)
+	ReverseMutation(context.Context, ReverseMutationRequest) (ReversalResponse, error)
+	CreateFXQuote(context.Context, CreateFXQuoteRequest) (FXQuoteResponse, error)
+	ConvertFX(context.Context, ConvertFXRequest) (ConversionResponse, error)
+	CreateHold(context.Context, CreateHoldRequest) (HoldResponse, error)
+	CaptureHold(context.Context, CaptureHoldRequest) (CaptureHoldResponse, error)
+	VoidHold(context.Context, VoidHoldRequest) (HoldResponse, error)
+	ListMutation(context.Context, MutationListRequest) (MutationListResponse, error)
+	// ExportMutation streams the wallet's statement to w. An invalid
+	// request or unknown wallet fails before anything is written.
+	ExportMutation(context.Context, MutationExportRequest, io.Writer) error
+	// Authenticate resolves a token to its wallet, returning
+	// ErrTokenNotFound if the token is unknown, expired or revoked.
+	Authenticate(ctx context.Context, token string) (Auth, error)
+	ListTokens(context.Context, ListTokensRequest) (TokenListResponse, error)
+	// CreateToken adds a token to the caller's wallet, returning
+	// ErrTokenExpiring if the caller's token is set to expire.
+	CreateToken(context.Context, CreateTokenRequest) (TokenResponse, error)
+	// RotateToken issues a new token with the same scopes and makes the
+	// one the request was made with stop working after the grace period.
+	// A token already set to expire cannot be rotated again.
+	RotateToken(context.Context, RotateTokenRequest) (TokenResponse, error)
+	RevokeToken(context.Context, RevokeTokenRequest) (TokenResponse, error)
 }
 
 type WalletRepository interface {
-	Create(Wallet) error
-	Enable(string) (Wallet, error)
-	GetByToken(string) (Wallet, error)
-	Disable(token string) (Wallet, error)
-	Mutate(Mutation, float64, string) error
-	SetWalletBalanceByToken(float64, string) error
-	CreateMutation(Mutation) error
-	ListMutation(ownerID string) ([]Mutation, error)
+	Create(context.Context, Wallet) error
+	Enable(ctx context.Context, id string) (Wallet, error)
+	// GetByToken returns the wallet of a token that has neither expired
+	// nor been revoked, and sql.ErrNoRows for any other token.
+	GetByToken(context.Context, string) (Wallet, error)
+	GetByID(context.Context, string) (Wallet, error)
+	// ListByOwnedBy returns every wallet of a customer.
+	ListByOwnedBy(context.Context, string) ([]Wallet, error)
+	Disable(ctx context.Context, id string) (Wallet, error)
+	// AddWalletBalanceByID adds a signed delta to the balance of an active
+	// wallet. It returns ErrWalletInactive, ErrInsufficientFunds or
+	// ErrBalanceOverflow, leaving the balance untouched, when the update
+	// cannot be applied.
+	AddWalletBalanceByID(ctx context.Context, delta Money, id string) (Wallet, error)
+	// LockWallets locks the wallets until the transaction ends. They are
+	// locked in ID order whatever the order of ids, so transactions that
+	// write to several wallets cannot deadlock on each other.
+	LockWallets(ctx context.Context, ids ...string) error
+	// Mutate atomically records the mutation and applies it to the balance
+	// of the wallet given by its WalletID. Concurrent calls are safe: the
+	// balance is never overdrawn and no mutation is lost. The mutation is
+	// also posted to the ledger. It returns ErrDuplicateReference if the
+	// reference ID is taken.
+	Mutate(context.Context, Mutation) (Wallet, error)
+	CreateToken(context.Context, Token) error
+	// GetToken looks up a token that has neither expired nor been revoked,
+	// returning ErrTokenNotFound otherwise.
+	GetToken(ctx context.Context, token string) (Token, error)
+	ListTokens(ctx context.Context, walletID string) ([]Token, error)
+	// ExpireToken makes the token stop working at the given time, unless
+	// it already expires earlier.
+	ExpireToken(ctx context.Context, id string, at time.Time) error
+	// RevokeToken invalidates the token at once. Revoking a revoked token
+	// keeps the original revocation time.
+	RevokeToken(ctx context.Context, id string, at time.Time) error
+	// CreateMutation returns ErrDuplicateReference if the reference ID is
+	// taken.
+	CreateMutation(context.Context, Mutation) error
+	GetMutationByReferenceID(context.Context, string) (Mutation, error)
+	GetMutationByID(context.Context, string) (Mutation, error)
+	// AddReversedAmount records a reversal of part of a successful
+	// mutation. It returns ErrReversalExceedsAmount if the mutation would
+	// end up reversed by more than its amount.
+	AddReversedAmount(ctx context.Context, id string, amount Money) error
+	// ListMutation returns the mutations matching the filter, newest
+	// first unless Ascending, ordered by creation time and then ID.
+	ListMutation(context.Context, MutationFilter) ([]Mutation, error)
+	// CreateHold stores the hold and adds its amount to the wallet's held
+	// total, provided the wallet is active and has that much available. It
+	// returns ErrWalletInactive or ErrInsufficientFunds otherwise, and
+	// ErrDuplicateReference if the reference ID is taken.
+	CreateHold(context.Context, Hold) error
+	GetHold(context.Context, string) (Hold, error)
+	GetHoldByReferenceID(context.Context, string) (Hold, error)
+	ListActiveHolds(ctx context.Context, walletID string) ([]Hold, error)
+	// ReleaseHold moves an active hold to a final status and removes its
+	// amount from the wallet's held total. It returns ErrHoldNotActive if
+	// the hold was already released.
+	ReleaseHold(ctx context.Context, id string, status HoldStatus, captured Money, mutationID string) (Hold, error)
+	// Ledger returns the ledger bound to the same connection or transaction.
+	Ledger() LedgerRepository
+	// FX returns the rates and quotes bound to the same connection or
+	// transaction.
+	FX() FXRepository
+	// WithinTransaction runs fn against a repository bound to a single
+	// transaction, committing when fn returns nil and rolling back
+	// otherwise, so several reads and writes succeed or fail together.
+	WithinTransaction(ctx context.Context, fn func(WalletRepository) error) error
 }
 
+var (
+	ErrWalletInactive     = apperror.Conflict("wallet_inactive", "wallet is inactive")
+	ErrInsufficientFunds  = apperror.New(apperror.KindUnprocessable, "insufficient_funds", "insufficient funds")
+	ErrBalanceOverflow    = apperror.New(apperror.KindUnprocessable, "balance_out_of_range", "balance would be too large")
+	ErrReferenceConflict  = apperror.Conflict("duplicate_reference", "reference_id was already used for a different request")
+	ErrRecipientInactive  = apperror.Conflict("recipient_inactive", "recipient wallet is inactive")
+	ErrRecipientNotFound  = apperror.NotFound("recipient_not_found", "recipient wallet not found")
+	ErrSameWallet         = apperror.Validation("same_wallet", "cannot transfer to the same wallet")
+	ErrRecipientAmbiguous = apperror.Validation("recipient_ambiguous", "recipient customer has several wallets, use to_wallet_id")
+
+	ErrMutationNotFound      = apperror.NotFound("mutation_not_found", "mutation not found")
+	ErrMutationNotReversible = apperror.Validation("mutation_not_reversible", "only successful deposits and withdrawals can be reversed")
+	ErrReversalExceedsAmount = apperror.Conflict("reversal_exceeds_amount", "reversal exceeds the amount left to reverse")
+)
+
+// ErrDuplicateReference is returned by a repository storing a mutation or
+// hold under a reference ID another one already has. Services check for
+// the reference ID first, so it means a concurrent request took it in the
+// meantime.
+var ErrDuplicateReference = errors.New("reference_id is already taken")
+
 type WalletStatus int
 
 const (
@@ -50,30 +158,41 @@ func (w *Wallet) GetStatusString() string {
 	return walletStatusToString[WalletStatus(w.Status)]
 }
 
+// AvailableBalance is the part of the balance not reserved by holds.
+func (w *Wallet) AvailableBalance() Money {
+	// Both amounts are in the currency of w and never negative, so the
+	// difference cannot overflow.
+	available, _ := w.Balance.Sub(w.Held)
+	return available
+}
+
 type (
 	Wallet struct {
 		ID        string       `db:"id"`
 		OwnedBy   string       `db:"owned_by"`
-		Token     string       `db:"token"`
 		Status    int          `db:"status"`
 		UpdatedAt sql.NullTime `db:"updated_at"`
-		Balance   float64      `db:"balance"`
+		Balance   Money        `db:"balance"`
+		// Held is the total of the wallet's active holds.
+		Held Money `db:"held"`
+		// Currency is the ISO 4217 code of every amount in the wallet.
+		Currency string `db:"currency"`
 	}
 
 	InitializeRequest struct {
-		CustomerID string `form:"customer_xid"`
+		CustomerID string `form:"customer_xid" json:"customer_xid"`
+		// Currency defaults to DefaultCurrency.
+		Currency string `form:"currency" json:"currency"`
 	}
 
 	InitializeResponse struct {
-		Token string `json:"token"`
-	}
-
-	WalletRequestHeader struct {
-		Authorization string `header:"Authorization"`
+		Token    string `json:"token"`
+		WalletID string `json:"wallet_id"`
+		Currency string `json:"currency"`
 	}
 
 	EnableRequest struct {
-		Token string
+		Auth Auth `form:"-" json:"-"`
 	}
 
 	EnableResponse struct {
@@ -81,24 +200,27 @@ type (
 		OwnedBy   string    `json:"owned_by"`
 		Status    string    `json:"status"`
 		EnabledAt time.Time `json:"enabled_at"`
-		Balance   float64   `json:"balance"`
+		Balance   Money     `json:"balance"`
+		Currency  string    `json:"currency"`
 	}
 
 	ViewBalanceRequest struct {
-		Token string
+		Auth Auth `form:"-" json:"-"`
 	}
 
 	ViewBalanceResponse struct {
-		ID        string    `json:"id"`
-		OwnedBy   string    `json:"owned_by"`
-		Status    string    `json:"status"`
-		EnabledAt time.Time `json:"enabled_at"`
-		Balance   float64   `json:"balance"`
+		ID               string    `json:"id"`
+		OwnedBy          string    `json:"owned_by"`
+		Status           string    `json:"status"`
+		EnabledAt        time.Time `json:"enabled_at"`
+		Balance          Money     `json:"balance"`
+		AvailableBalance Money     `json:"available_balance"`
+		Currency         string    `json:"currency"`
 	}
 
 	DisableRequest struct {
-		Token      string
-		IsDisabled bool `form:"is_disabled"`
+		Auth       Auth `form:"-" json:"-"`
+		IsDisabled bool `form:"is_disabled" json:"is_disabled"`
 	}
 
 	DisableResponse struct {
@@ -106,13 +228,16 @@ type (
 		OwnedBy    string    `json:"owned_by"`
 		Status     string    `json:"status"`
 		DisabledAt time.Time `json:"disabled_at"`
-		Balance    float64   `json:"balance"`
+		Balance    Money     `json:"balance"`
+		Currency   string    `json:"currency"`
 	}
 
 	DepositRequest struct {
-		Token       string
-		ReferenceID string  `form:"reference_id"`
-		Amount      float64 `form:"amount"`
+		Auth        Auth   `form:"-" json:"-"`
+		ReferenceID string `form:"reference_id" json:"reference_id"`
+		Amount      Money  `form:"amount" json:"amount"`
+		// Currency is optional and must match the wallet currency.
+		Currency string `form:"currency" json:"currency"`
 	}
 
 	DepositResponse struct {
@@ -120,14 +245,22 @@ type (
 		DepositedBy string    `json:"deposited_by"`
 		Status      string    `json:"status"`
 		DepositedAt time.Time `json:"deposited_at"`
-		Amount      float64   `json:"amount"`
+		Amount      Money     `json:"amount"`
+		Currency    string    `json:"currency"`
 		ReferenceID string    `json:"reference_id"`
+		// FailureReason explains a "fail" status, see MutationFailureReason.
+		FailureReason string `json:"failure_reason,omitempty"`
+		// Replayed is set when the reference ID had already been processed
+		// and this is the original result rather than a new mutation.
+		Replayed bool `json:"-"`
 	}
 
 	WithdrawRequest struct {
-		Token       string
-		ReferenceID string  `form:"reference_id"`
-		Amount      float64 `form:"amount"`
+		Auth        Auth   `form:"-" json:"-"`
+		ReferenceID string `form:"reference_id" json:"reference_id"`
+		Amount      Money  `form:"amount" json:"amount"`
+		// Currency is optional and must match the wallet currency.
+		Currency string `form:"currency" json:"currency"`
 	}
 
 	WithdrawResponse struct {
@@ -135,11 +268,85 @@ type (
 		WithdrawnBy string    `json:"withdrawn_by"`
 		Status      string    `json:"status"`
 		WithdrawnAt time.Time `json:"withdrawn_at"`
-		Amount      float64   `json:"amount"`
+		Amount      Money     `json:"amount"`
+		Currency    string    `json:"currency"`
 		ReferenceID string    `json:"reference_id"`
+		// FailureReason explains a "fail" status, see MutationFailureReason.
+		FailureReason string `json:"failure_reason,omitempty"`
+		// Replayed is set when the reference ID had already been processed
+		// and this is the original result rather than a new mutation.
+		Replayed bool `json:"-"`
 	}
 
+	// MutationListRequest filters and pages through a wallet's history.
+	// Every filter is optional; From is inclusive and To exclusive.
 	MutationListRequest struct {
-		Token string
+		Auth        Auth      `form:"-" json:"-"`
+		Actions     []string  `form:"action"`
+		Status      string    `form:"status"`
+		MinAmount   *Money    `form:"min_amount"`
+		MaxAmount   *Money    `form:"max_amount"`
+		From        time.Time `form:"from"`
+		To          time.Time `form:"to"`
+		ReferenceID string    `form:"reference_id"`
+		// Cursor is the next_cursor of the previous page.
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	MutationListResponse struct {
+		Transactions []interface{} `json:"transactions"`
+		// NextCursor is empty on the last page.
+		NextCursor string `json:"next_cursor,omitempty"`
+	}
+
+	TransferRequest struct {
+		Auth         Auth   `form:"-" json:"-"`
+		ToCustomerID string `form:"to_customer_xid" json:"to_customer_xid"`
+		ToWalletID   string `form:"to_wallet_id" json:"to_wallet_id"`
+		ReferenceID  string `form:"reference_id" json:"reference_id"`
+		Amount       Money  `form:"amount" json:"amount"`
+		// Currency is optional and must match the wallet currency.
+		Currency string `form:"currency" json:"currency"`
+	}
+
+	TransferResponse struct {
+		ID            string    `json:"id"`
+		OwnedBy       string    `json:"owned_by"`
+		Direction     string    `json:"direction"`
+		Counterparty  string    `json:"counterparty_wallet_id"`
+		Status        string    `json:"status"`
+		TransferredAt time.Time `json:"transferred_at"`
+		Amount        Money     `json:"amount"`
+		Currency      string    `json:"currency"`
+		ReferenceID   string    `json:"reference_id"`
+		// FailureReason explains a "fail" status, see MutationFailureReason.
+		FailureReason string `json:"failure_reason,omitempty"`
+		// Replayed is set when the reference ID had already been processed
+		// and this is the original result rather than a new mutation.
+		Replayed bool `json:"-"`
+	}
+
+	ReverseMutationRequest struct {
+		MutationID  string `form:"-" json:"-"`
+		ReferenceID string `form:"reference_id" json:"reference_id"`
+		// Amount defaults to everything not yet reversed when zero.
+		Amount Money `form:"amount" json:"amount"`
+	}
+
+	ReversalResponse struct {
+		ID          string    `json:"id"`
+		OwnedBy     string    `json:"owned_by"`
+		ReversedID  string    `json:"reversed_mutation_id"`
+		Status      string    `json:"status"`
+		ReversedAt  time.Time `json:"reversed_at"`
+		Amount      Money     `json:"amount"`
+		Currency    string    `json:"currency"`
+		ReferenceID string    `json:"reference_id"`
+		// FailureReason explains a "fail" status, see MutationFailureReason.
+		FailureReason string `json:"failure_reason,omitempty"`
+		// Replayed is set when the reference ID had already been processed
+		// and this is the original result rather than a new mutation.
+		Replayed bool `json:"-"`
 	}
 )
@@ -1,10 +1,11 @@
 package utils
 
 import (
-	"context"
 	"encoding/json"
 	"log"
 	"net/http"
+
+	"github.com/otnayrus/simple-wallet-app/types/apperror"
 )
 
 const (
@@ -17,14 +18,19 @@ type RestResponse struct {
 	Data   interface{} `json:"data"`
 }
 
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
 func MakeRestResponse(w http.ResponseWriter, data interface{}, status int, err error) {
 	var statusStr string = StatusSuccess
 	if err != nil {
 		statusStr = StatusError
 		data = struct {
-			Error error `json:"error"`
+			Error ErrorResponse `json:"error"`
 		}{
-			Error: err,
+			Error: makeErrorResponse(err),
 		}
 	}
 
@@ -44,8 +50,15 @@ func MakeRestResponse(w http.ResponseWriter, data interface{}, status int, err e
 	w.Write(marshal)
 }
 
-func InitRestContext() context.Context {
-	return context.Background()
+// makeErrorResponse hides the message of errors that are not meant for
+// clients, logging it instead.
+func makeErrorResponse(err error) ErrorResponse {
+	code := apperror.Code(err)
+	if code == apperror.CodeInternal {
+		log.Println("internal error:", err)
+	}
+
+	return ErrorResponse{Code: code, Message: apperror.Message(err)}
 }
 
 type WalletWrapper struct {
@@ -60,6 +73,14 @@ type WithdrawWrapper struct {
 	Withdraw interface{} `json:"withdraw"`
 }
 
+type TransferWrapper struct {
+	Transfer interface{} `json:"transfer"`
+}
+
+type HoldWrapper struct {
+	Hold interface{} `json:"hold"`
+}
+
 func AddWalletWrapper(data interface{}) WalletWrapper {
 	return WalletWrapper{
 		Wallet: data,
@@ -77,3 +98,65 @@ func AddWithdrawWrapper(data interface{}) WithdrawWrapper {
 		Withdraw: data,
 	}
 }
+
+func AddTransferWrapper(data interface{}) TransferWrapper {
+	return TransferWrapper{
+		Transfer: data,
+	}
+}
+
+func AddHoldWrapper(data interface{}) HoldWrapper {
+	return HoldWrapper{
+		Hold: data,
+	}
+}
+
+type ReversalWrapper struct {
+	Reversal interface{} `json:"reversal"`
+}
+
+func AddReversalWrapper(data interface{}) ReversalWrapper {
+	return ReversalWrapper{
+		Reversal: data,
+	}
+}
+
+type QuoteWrapper struct {
+	Quote interface{} `json:"quote"`
+}
+
+func AddQuoteWrapper(data interface{}) QuoteWrapper {
+	return QuoteWrapper{
+		Quote: data,
+	}
+}
+
+type ConversionWrapper struct {
+	Conversion interface{} `json:"conversion"`
+}
+
+func AddConversionWrapper(data interface{}) ConversionWrapper {
+	return ConversionWrapper{
+		Conversion: data,
+	}
+}
+
+type RateWrapper struct {
+	Rate interface{} `json:"rate"`
+}
+
+func AddRateWrapper(data interface{}) RateWrapper {
+	return RateWrapper{
+		Rate: data,
+	}
+}
+
+type TokenWrapper struct {
+	Token interface{} `json:"token"`
+}
+
+func AddTokenWrapper(data interface{}) TokenWrapper {
+	return TokenWrapper{
+		Token: data,
+	}
+}